@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/sted/heligo"
 	"github.com/sted/smoothdb/database"
@@ -18,7 +19,14 @@ func InitSourcesRouter(apiHelper Helper) {
 
 	// TABLES
 
-	api.Handle("GET", "", TableListHandler)
+	api.Handle("GET", "", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		doc, err := database.GetOpenAPI(c)
+		if err == nil {
+			return heligo.WriteJSON(w, http.StatusOK, doc)
+		} else {
+			return WriteServerError(w, err)
+		}
+	})
 	api.Handle("GET", "/$info/:table", TableGetHandler)
 
 	// RECORDS
@@ -90,6 +98,25 @@ func InitSourcesRouter(apiHelper Helper) {
 		}
 	})
 
+	api.Handle("PUT", "/:sourcename", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		sourcename := r.Param("sourcename")
+		records, status, err := ReadRequest(c, w, r)
+		if err != nil || status != 0 {
+			return status, err
+		}
+		data, count, err := database.UpsertRecords(c, sourcename, records, r.URL.Query())
+		if err == nil {
+			SetResponseHeaders(c, w, r, count)
+			if data == nil {
+				return heligo.WriteHeader(w, http.StatusNoContent)
+			} else {
+				return WriteContent(c, w, http.StatusOK, data)
+			}
+		} else {
+			return WriteError(w, err)
+		}
+	})
+
 	api.Handle("DELETE", "/:sourcename", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
 		sourcename := r.Param("sourcename")
 		data, count, err := database.DeleteRecords(c, sourcename, r.URL.Query())
@@ -105,6 +132,17 @@ func InitSourcesRouter(apiHelper Helper) {
 		}
 	})
 
+	api.Handle("OPTIONS", "/:sourcename", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		sourcename := r.Param("sourcename")
+		methods, err := database.GetAllowedMethods(c, sourcename)
+		if err == nil {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			return heligo.WriteHeader(w, http.StatusOK)
+		} else {
+			return WriteServerError(w, err)
+		}
+	})
+
 	// FUNCTIONS
 
 	api.Handle("GET", "/rpc/:fname", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
@@ -148,4 +186,15 @@ func InitSourcesRouter(apiHelper Helper) {
 			return WriteError(w, err)
 		}
 	})
+
+	api.Handle("OPTIONS", "/rpc/:fname", func(c context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fname := r.Param("fname")
+		methods, err := database.GetFunctionAllowedMethods(c, fname)
+		if err == nil {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			return heligo.WriteHeader(w, http.StatusOK)
+		} else {
+			return WriteServerError(w, err)
+		}
+	})
 }
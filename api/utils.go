@@ -110,6 +110,10 @@ func WriteError(w http.ResponseWriter, err error) (int, error) {
 	switch err.(type) {
 	case *database.ParseError, *database.BuildError, *jqeval.Error:
 		return WriteBadRequest(w, err)
+	case *database.MethodError:
+		status = http.StatusMethodNotAllowed
+		heligo.WriteJSON(w, status, SmoothError{Message: err.Error(), Subsystem: "network"})
+		return status, err
 	case *database.SerializeError, *database.ContentTypeError:
 		status = http.StatusNotAcceptable
 		w.WriteHeader(status)
@@ -348,7 +352,8 @@ func ReadRequest(c context.Context, w http.ResponseWriter, r heligo.Request) (re
 	return records, status, err
 }
 
-// SetResponseHeaders sets the response headers (for now Content-Range and Content-Location).
+// SetResponseHeaders sets the response headers (for now Content-Range, Content-Location, Location,
+// Server-Timing and Next-Cursor).
 // It returns a status != 0 if some contraints are not satisfied and we need to include an error status
 // in the response (eg 416 for RequestedRangeNotSatisfiable)
 func SetResponseHeaders(ctx context.Context, w http.ResponseWriter, r heligo.Request, count int64) int {
@@ -374,13 +379,24 @@ func SetResponseHeaders(ctx context.Context, w http.ResponseWriter, r heligo.Req
 	} else {
 		rangeString = "*"
 	}
-	if options.Count == "" {
+	if options.Count == "" && !options.ReturnHeadersOnly {
 		rangeString += "/*"
 	} else {
 		rangeString += "/" + strconv.FormatInt(count, 10)
 	}
 	w.Header().Set("Content-Range", rangeString)
-	if options.RangeMin > count && options.Count != "" ||
+	if options.Location != "" {
+		w.Header().Set("Location", r.URL.Path+"?"+options.Location)
+	}
+	if sc.Timing != nil {
+		w.Header().Set("Server-Timing", sc.Timing.String())
+	}
+	// keyset pagination
+	if options.NextCursor != "" {
+		w.Header().Set("Next-Cursor", options.NextCursor)
+	}
+	// planned and estimated counts are approximate: they cannot tell that a range is not satisfiable
+	if options.RangeMin > count && options.Count == "exact" ||
 		options.RangeMin > -1 && options.RangeMax > -1 && options.RangeMin > options.RangeMax {
 		return http.StatusRequestedRangeNotSatisfiable
 	}
@@ -395,7 +411,7 @@ func SetResponseHeaders(ctx context.Context, w http.ResponseWriter, r heligo.Req
 func WriteContent(ctx context.Context, w http.ResponseWriter, status int, content []byte) (int, error) {
 	sc := database.GetSmoothContext(ctx)
 	ct := sc.QueryOptions.ContentType
-	if ct == "application/json" || ct == "text/csv" {
+	if ct == "application/json" || ct == "text/csv" || sc.QueryOptions.Plan != "" {
 		ct += "; charset=utf-8"
 	}
 	w.Header().Set("Content-Type", ct)
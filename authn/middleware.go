@@ -6,6 +6,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/sted/heligo"
 	"github.com/sted/smoothdb/database"
@@ -113,6 +115,41 @@ func (m middleware) releaseSession(ctx context.Context, status int, session *Ses
 	m.SessionManager().leaveSession(session)
 }
 
+// preferenceWriter sets the Preference-Applied header of every response, errors
+// included, when it is written: only then the request has decided the preferences
+// it applied
+type preferenceWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	written bool
+}
+
+func (pw *preferenceWriter) setHeader() {
+	if pw.written {
+		return
+	}
+	pw.written = true
+	sc := database.GetSmoothContext(pw.ctx)
+	if sc != nil && len(sc.QueryOptions.PreferenceApplied) > 0 {
+		pw.Header().Set("Preference-Applied", strings.Join(sc.QueryOptions.PreferenceApplied, ", "))
+	}
+}
+
+func (pw *preferenceWriter) WriteHeader(status int) {
+	pw.setHeader()
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *preferenceWriter) Write(b []byte) (int, error) {
+	pw.setHeader()
+	return pw.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the original writer
+func (pw *preferenceWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
 func Middleware(cfg MiddlewareConfig, forceDBE bool, getDBName GetDatabaseNameFn) heligo.Middleware {
 	m := middleware{cfg}
 	return func(next heligo.Handler) heligo.Handler {
@@ -124,13 +161,15 @@ func Middleware(cfg MiddlewareConfig, forceDBE bool, getDBName GetDatabaseNameFn
 			} else {
 				w.Header().Set("Server", "smoothdb")
 			}
+			start := time.Now()
 			ctx, session, status, err := m.acquireSession(c, r, forceDBE, getDBName)
 			if err != nil {
 				heligo.WriteJSON(w, status, map[string]string{"error": err.Error()})
 				return status, err
 			}
+			database.TrackTiming(ctx, "acquire", start)
 			//w.(http.Flusher).Flush() // to enable Transfer-Encoding: chunked
-			status, err = next(ctx, w, r)
+			status, err = next(ctx, &preferenceWriter{ResponseWriter: w, ctx: ctx}, r)
 			m.releaseSession(ctx, status, session)
 			return status, err
 		}
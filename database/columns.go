@@ -1,6 +1,9 @@
 package database
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 type Column struct {
 	Name        string   `json:"name"`
@@ -187,13 +190,16 @@ func DeleteColumn(ctx context.Context, tablename string, name string, cascade bo
 }
 
 type ColumnType struct {
-	Table       string `json:"table"`
-	Schema      string `json:"schema"`
-	Name        string `json:"name"`
-	Type        string `json:"type"`
-	DataType    string `json:"datatype"`
-	IsArray     bool   `json:"isarray"`
-	IsComposite bool   `json:"iscomposite"`
+	Table        string `json:"table"`
+	Schema       string `json:"schema"`
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	DataType     string `json:"datatype"`
+	IsArray      bool   `json:"isarray"`
+	IsComposite  bool   `json:"iscomposite"`
+	IsMultirange bool   `json:"ismultirange"`
+	RangeType    string `json:"rangetype"` // the range type of a multirange
+	Position     int    `json:"position"`
 }
 
 const columnTypesQuery = `
@@ -204,20 +210,39 @@ const columnTypesQuery = `
 		c.udt_name type,		
 		c.data_type datatype,
 		(t.typcategory = 'A') AS isarray,
-		(t.typcategory = 'C') AS iscomposite
+		(t.typcategory = 'C') AS iscomposite,
+		(t.typtype = 'm') AS ismultirange,
+		COALESCE(r.rngtypid::regtype::text, '') rangetype,
+		c.ordinal_position::int position
 	FROM
 		information_schema.columns c
 		JOIN pg_type t ON c.udt_name = t.typname and c.udt_schema::regnamespace = t.typnamespace
+		LEFT JOIN pg_range r ON r.rngmultitypid = t.oid
 	WHERE
 		c.table_schema !~ '^pg_' AND c.table_schema <> 'information_schema'
 	ORDER BY
 		table_name, table_schema, ordinal_position;
 `
 
+// columnTypesWithoutMultiranges removes the multirange join from columnTypesQuery,
+// for servers before multirangeVersion
+var columnTypesWithoutMultiranges = strings.NewReplacer(
+	"COALESCE(r.rngtypid::regtype::text, '') rangetype,", "'' rangetype,",
+	"LEFT JOIN pg_range r ON r.rngmultitypid = t.oid", "",
+)
+
 func GetColumnTypes(ctx context.Context) ([]ColumnType, error) {
 	conn := GetConn(ctx)
 	types := []ColumnType{}
-	rows, err := conn.Query(ctx, columnTypesQuery)
+	version, err := serverVersionNum(ctx, conn)
+	if err != nil {
+		return types, err
+	}
+	query := columnTypesQuery
+	if version < multirangeVersion {
+		query = columnTypesWithoutMultiranges.Replace(query)
+	}
+	rows, err := conn.Query(ctx, query)
 	if err != nil {
 		return types, err
 	}
@@ -225,7 +250,7 @@ func GetColumnTypes(ctx context.Context) ([]ColumnType, error) {
 
 	typ := ColumnType{}
 	for rows.Next() {
-		err := rows.Scan(&typ.Table, &typ.Schema, &typ.Name, &typ.Type, &typ.DataType, &typ.IsArray, &typ.IsComposite)
+		err := rows.Scan(&typ.Table, &typ.Schema, &typ.Name, &typ.Type, &typ.DataType, &typ.IsArray, &typ.IsComposite, &typ.IsMultirange, &typ.RangeType, &typ.Position)
 		if err != nil {
 			return types, err
 		}
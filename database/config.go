@@ -3,25 +3,34 @@ package database
 const DEFAULT_ANON = "anon"
 
 type Config struct {
-	URL                string   `comment:"Database URL"`
-	MinPoolConnections int32    `comment:"Miminum connections per pool (default: 10)"`
-	MaxPoolConnections int32    `comment:"Maximum connections per pool (default: 100)"`
-	AnonRole           string   `comment:"Anonymous role (default: '' for no anon)"`
-	AllowedDatabases   []string `comment:"Allowed databases (default: [] for all)"`
-	SchemaSearchPath   []string `comment:"Schema search path (default: [] for Postgres search path)"`
-	TransactionMode    string   `comment:"General transaction mode for operations: none, commit, commit-allow-override, rollback, rollback-allow-override (default: none)"`
-	AggregatesEnabled  bool     `comment:"Enable aggregate functions (default: true)"`
-	MaxRecursiveDepth  int      `comment:"Maximum recursive query depth; 0 disables recursive queries (default: 100)"`
+	URL                     string   `comment:"Database URL"`
+	MinPoolConnections      int32    `comment:"Miminum connections per pool (default: 10)"`
+	MaxPoolConnections      int32    `comment:"Maximum connections per pool (default: 100)"`
+	AnonRole                string   `comment:"Anonymous role (default: '' for no anon)"`
+	AllowedDatabases        []string `comment:"Allowed databases (default: [] for all)"`
+	SchemaSearchPath        []string `comment:"Schema search path (default: [] for Postgres search path)"`
+	TransactionMode         string   `comment:"General transaction mode for operations: none, commit, commit-allow-override, rollback, rollback-allow-override (default: none)"`
+	AggregatesEnabled       bool     `comment:"Enable aggregate functions (default: true)"`
+	MaxRecursiveDepth       int      `comment:"Maximum recursive query depth; 0 disables recursive queries (default: 100)"`
+	EstimatedCountThreshold int      `comment:"Rows counted exactly with count=estimated; above it the planner estimate is used (default: 1000)"`
+	RequestParser           string   `comment:"Query string syntax: postgrest, django; requests can override it with the Request-Parser header (default: postgrest)"`
+	UnfilteredWritesEnabled bool     `comment:"Allow UPDATE and DELETE without filters; when false a request must opt in with Prefer: unfiltered=allow (default: true)"`
+	ServerTimingEnabled     bool     `comment:"Return the durations of the request phases in the Server-Timing header (default: false)"`
+	PlanRoles               []string `comment:"Roles allowed to get execution plans with Accept: application/vnd.pgrst.plan (default: [] for none)"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		URL:                "",
-		MinPoolConnections: 10,
-		MaxPoolConnections: 100,
-		AnonRole:           "",
-		TransactionMode:    "none",
-		AggregatesEnabled:  true,
-		MaxRecursiveDepth:  100,
+		URL:                     "",
+		MinPoolConnections:      10,
+		MaxPoolConnections:      100,
+		AnonRole:                "",
+		TransactionMode:         "none",
+		AggregatesEnabled:       true,
+		MaxRecursiveDepth:       100,
+		EstimatedCountThreshold: 1000,
+		RequestParser:           "postgrest",
+		UnfilteredWritesEnabled: true,
+		ServerTimingEnabled:     false,
 	}
 }
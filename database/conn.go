@@ -14,6 +14,13 @@ func HasTX(c *DbPoolConn) bool {
 	return c.Conn().PgConn().TxStatus() != 'I'
 }
 
+// serverVersionNum returns the version of the PostgreSQL server, like 160002 for 16.2
+func serverVersionNum(ctx context.Context, conn *DbConn) (int, error) {
+	var version int
+	err := conn.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version)
+	return version, err
+}
+
 // AcquireConnection takes a connection from a database pool.
 // If the db parameter is nil, it uses the main db pool.
 func AcquireConnection(ctx context.Context, db *Database) (conn *DbPoolConn, err error) {
@@ -11,6 +11,7 @@ type mediaType struct {
 	Type        string
 	Quality     float64
 	Specificity int
+	Params      map[string]string
 }
 
 // List of supported content type for output (Accept header)
@@ -19,14 +20,19 @@ var supportedOutputContentTypes = []string{
 	"application/vnd.pgrst.object+json",
 	"text/csv",
 	"application/octet-stream",
+	"application/vnd.pgrst.plan+json",
+	"application/vnd.pgrst.plan+text",
+	"application/vnd.pgrst.plan",
+	"application/geo+json",
 }
 var defaultOutputContentType = "application/json"
 
 // contentNegotiation to choose the output content type.
-// It Supports also q (quality) parameters
-func contentNegotiation(acceptHeaders []string) string {
+// It Supports also q (quality) parameters and returns the parameters of the chosen
+// type (like options for the plan types).
+func contentNegotiation(acceptHeaders []string) (string, map[string]string) {
 	if len(acceptHeaders) == 0 {
-		return defaultOutputContentType
+		return defaultOutputContentType, nil
 	}
 
 	parsedTypes := parseMediaTypes(acceptHeaders)
@@ -34,15 +40,15 @@ func contentNegotiation(acceptHeaders []string) string {
 	for _, parsedType := range parsedTypes {
 		for _, supportedType := range supportedOutputContentTypes {
 			if parsedType.Type == supportedType {
-				return supportedType
+				return supportedType, parsedType.Params
 			}
 			if matchesWildcard(parsedType.Type, supportedType) {
-				return supportedType
+				return supportedType, nil
 			}
 		}
 	}
 
-	return ""
+	return "", nil
 }
 
 func parseMediaTypes(acceptHeaders []string) []mediaType {
@@ -73,7 +79,7 @@ func parseMediaTypes(acceptHeaders []string) []mediaType {
 				specificity = 2
 			}
 
-			types = append(types, mediaType{Type: mt, Quality: q, Specificity: specificity})
+			types = append(types, mediaType{Type: mt, Quality: q, Specificity: specificity, Params: params})
 		}
 	}
 
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"net/http"
+	"strings"
 )
 
 type smoothCtxKey struct{}
@@ -18,15 +19,35 @@ type SmoothContext struct {
 	RequestParser RequestParser
 	QueryBuilder  QueryBuilder
 	QueryOptions  QueryOptions
+	Timing        *ServerTiming // durations of the request phases, nil if not enabled
 }
 
 // FillContext compiles and inserts the information related to the database, cresting a new derived context
 func FillContext(ctx context.Context, r *http.Request, db *Database, conn *DbConn, role string) context.Context {
-	defaultParser := PostgRestParser{}
+	parser := requestParserFor(r)
 	defaultBuilder := DirectQueryBuilder{}
-	queryOptions := defaultParser.getQueryOptions(r)
+	queryOptions := parser.getQueryOptions(r)
+	var timing *ServerTiming
+	if serverTimingEnabled() {
+		timing = &ServerTiming{}
+	}
 	return context.WithValue(ctx, smoothTag,
-		&SmoothContext{db, conn, role, defaultParser, defaultBuilder, queryOptions})
+		&SmoothContext{db, conn, role, parser, defaultBuilder, queryOptions, timing})
+}
+
+// requestParserFor selects the request parser configured for the server,
+// that a request can override with the Request-Parser header
+func requestParserFor(r *http.Request) RequestParser {
+	mode := r.Header.Get("Request-Parser")
+	if mode == "" && dbe != nil {
+		mode = dbe.config.RequestParser
+	}
+	switch strings.ToLower(mode) {
+	case "django":
+		return DjangoParser{}
+	default:
+		return PostgRestParser{}
+	}
 }
 
 // GetSmoothContext gets SmoothContext from the standard context
@@ -75,7 +96,7 @@ func contextImpl(parent context.Context, db *Database, conn *DbConn, role string
 	defaultBuilder := DirectQueryBuilder{}
 
 	return context.WithValue(parent, smoothTag,
-		&SmoothContext{db, conn, role, defaultParser, defaultBuilder, queryOptions})
+		&SmoothContext{db, conn, role, defaultParser, defaultBuilder, queryOptions, nil})
 }
 
 // GetConn gets the database connection from the current context
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync/atomic"
 
@@ -90,6 +91,12 @@ func (db *Database) activate(ctx context.Context) (err error) {
 			}
 			conn.TypeMap().RegisterType(&pgtype.Type{Name: t.Name, OID: t.Id, Codec: &pgtype.CompositeCodec{Fields: fields}})
 		}
+		// pgvector values are exchanged as text, which is a JSON array:
+		// this encodes the arrays of numbers in the records
+		for _, t := range info.cachedVectors {
+			conn.TypeMap().RegisterType(&pgtype.Type{Name: t.Name, OID: t.Id,
+				Codec: &pgtype.TextFormatOnlyCodec{Codec: &pgtype.JSONCodec{Marshal: json.Marshal, Unmarshal: json.Unmarshal}}})
+		}
 		return nil
 	}
 
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+// DjangoParser parses query strings written with the Django (and Django REST framework)
+// conventions, producing the same AST of PostgRestParser:
+//
+//	/table?fields=id,name&age__gte=18&name__icontains=bob&ordering=-created&limit=10&offset=20
+//
+// Filters are expressed as field__lookup=value, or field=value for exact matches,
+// and they are all combined with AND.
+type DjangoParser struct{}
+
+var djangoReservedWords = map[string]struct{}{
+	"fields": {}, "ordering": {}, "limit": {}, "offset": {}, "jq": {}, "jq_args": {},
+}
+
+// djangoLookups maps the supported lookups to the SQL operators
+var djangoLookups = map[string]string{
+	"exact":       "=",
+	"iexact":      "ILIKE",
+	"contains":    "LIKE",
+	"icontains":   "ILIKE",
+	"startswith":  "LIKE",
+	"istartswith": "ILIKE",
+	"endswith":    "LIKE",
+	"iendswith":   "ILIKE",
+	"gt":          ">",
+	"gte":         ">=",
+	"lt":          "<",
+	"lte":         "<=",
+	"in":          "IN",
+	"isnull":      "IS",
+	"range":       "",
+}
+
+// splitLookup splits a filter key in the field name and the lookup (exact if missing)
+func splitLookup(key string) (field, lookup string) {
+	if i := strings.LastIndex(key, "__"); i > 0 {
+		if _, ok := djangoLookups[key[i+2:]]; ok {
+			return key[:i], key[i+2:]
+		}
+	}
+	return key, "exact"
+}
+
+// escapeLike escapes the LIKE wildcards in a value
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (p DjangoParser) parse(mainTable string, filters Filters) (parts *QueryParts, err error) {
+	parts = &QueryParts{}
+
+	// FIELDS
+	// fields=id,name
+	if fieldsFilter, ok := filters["fields"]; ok {
+		for _, list := range fieldsFilter {
+			for _, name := range strings.Split(list, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					return nil, &ParseError{"field expected"}
+				}
+				parts.selectFields = append(parts.selectFields, SelectField{field: Field{name: name}})
+			}
+		}
+		delete(filters, "fields")
+	}
+
+	// JQ
+	delete(filters, "jq")
+	delete(filters, "jq_args")
+
+	// ORDERING
+	// ordering=-created,name
+	if orderingFilter, ok := filters["ordering"]; ok {
+		for _, list := range orderingFilter {
+			for _, name := range strings.Split(list, ",") {
+				name = strings.TrimSpace(name)
+				descending := strings.HasPrefix(name, "-")
+				name = strings.TrimPrefix(name, "-")
+				if name == "" {
+					return nil, &ParseError{"ordering field expected"}
+				}
+				parts.orderFields = append(parts.orderFields,
+					OrderField{field: Field{name: name, tablename: mainTable}, descending: descending})
+			}
+		}
+		delete(filters, "ordering")
+	}
+
+	// LIMIT AND OFFSET
+	if limitFilter, ok := filters["limit"]; ok {
+		if !isNonNegativeInt(limitFilter[0]) {
+			return nil, &ParseError{"limit must be a non-negative integer"}
+		}
+		parts.limit = limitFilter[0]
+		delete(filters, "limit")
+	}
+	if offsetFilter, ok := filters["offset"]; ok {
+		if !isNonNegativeInt(offsetFilter[0]) {
+			return nil, &ParseError{"offset must be a non-negative integer"}
+		}
+		parts.offset = offsetFilter[0]
+		delete(filters, "offset")
+	}
+
+	// WHERE
+	// age__gte=18&name__icontains=bob&deleted_at__isnull=true
+	keys := []string{}
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // canonical order, as PostgRestParser
+	parts.whereConditionsTree = &WhereConditionNode{}
+	for _, k := range keys {
+		name, lookup := splitLookup(k)
+		if strings.Contains(name, "__") {
+			return nil, &ParseError{"unsupported lookup in '" + k + "'"}
+		}
+		for _, v := range filters[k] {
+			nodes, err := djangoCondition(mainTable, name, lookup, v)
+			if err != nil {
+				return nil, err
+			}
+			parts.whereConditionsTree.children = append(parts.whereConditionsTree.children, nodes...)
+		}
+	}
+	return parts, nil
+}
+
+// djangoCondition creates the condition nodes for a filter
+func djangoCondition(table, name, lookup, value string) ([]*WhereConditionNode, error) {
+	// only isnull tests nulls: name=null compares with the string 'null'
+	node := &WhereConditionNode{field: Field{name: name, tablename: table}, operator: djangoLookups[lookup], opSource: lookup,
+		bound: lookup != "isnull"}
+	switch lookup {
+	case "iexact":
+		node.values = []string{escapeLike(value)}
+	case "contains", "icontains":
+		node.values = []string{"%" + escapeLike(value) + "%"}
+	case "startswith", "istartswith":
+		node.values = []string{escapeLike(value) + "%"}
+	case "endswith", "iendswith":
+		node.values = []string{"%" + escapeLike(value)}
+	case "in":
+		if value != "" {
+			node.values = strings.Split(value, ",")
+		}
+	case "isnull":
+		switch strings.ToLower(value) {
+		case "true", "1":
+			node.values = []string{"null"}
+		case "false", "0":
+			node.values = []string{"not_null"}
+		default:
+			return nil, &ParseError{"isnull requires true or false"}
+		}
+	case "range":
+		// range=a,b is between a and b, inclusive
+		bounds := strings.Split(value, ",")
+		if len(bounds) != 2 {
+			return nil, &ParseError{"range requires two values"}
+		}
+		return []*WhereConditionNode{
+			{field: node.field, operator: ">=", opSource: "gte", values: []string{bounds[0]}, bound: true},
+			{field: node.field, operator: "<=", opSource: "lte", values: []string{bounds[1]}, bound: true},
+		}, nil
+	default:
+		node.values = []string{value}
+	}
+	return []*WhereConditionNode{node}, nil
+}
+
+func (p DjangoParser) getQueryOptions(req *Request) QueryOptions {
+	// the headers are the same of PostgREST
+	return PostgRestParser{}.getQueryOptions(req)
+}
+
+// filterParameters returns the parameters that are not filters, used as function arguments:
+// the keys that are not reserved words and have no lookup.
+func (p DjangoParser) filterParameters(filters Filters) Filters {
+	skipped := make(Filters)
+	for k, vv := range filters {
+		if _, exists := djangoReservedWords[k]; exists {
+			continue
+		}
+		if _, lookup := splitLookup(k); lookup == "exact" && !strings.HasSuffix(k, "__exact") {
+			skipped[k] = vv
+			delete(filters, k)
+		}
+	}
+	return skipped
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"strings"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type Privilege struct {
@@ -246,3 +248,80 @@ func DeletePrivilege(ctx context.Context, privilege *Privilege) error {
 	_, err = conn.Exec(ctx, revoke)
 	return err
 }
+
+// allowedCommandsQuery checks which commands the current role can use on a relation.
+// Writes also need an updatable relation (views) and, when row level security is active,
+// a policy for the command that applies to the role.
+const allowedCommandsQuery = `
+	SELECT
+		has_any_column_privilege(c.oid, 'SELECT'),
+		has_any_column_privilege(c.oid, 'INSERT') AND pg_relation_is_updatable(c.oid, true) & 8 = 8 AND
+			(NOT row_security_active(c.oid) OR COALESCE(pol.cmds && '{*,a}'::"char"[], false)),
+		has_any_column_privilege(c.oid, 'UPDATE') AND pg_relation_is_updatable(c.oid, true) & 4 = 4 AND
+			(NOT row_security_active(c.oid) OR COALESCE(pol.cmds && '{*,w}'::"char"[], false)),
+		has_table_privilege(c.oid, 'DELETE') AND pg_relation_is_updatable(c.oid, true) & 16 = 16 AND
+			(NOT row_security_active(c.oid) OR COALESCE(pol.cmds && '{*,d}'::"char"[], false))
+	FROM pg_class c
+	LEFT JOIN LATERAL (
+		SELECT array_agg(p.polcmd) cmds
+		FROM pg_policy p
+		WHERE p.polrelid = c.oid AND EXISTS (
+			SELECT 1 FROM unnest(p.polroles) r
+			WHERE CASE WHEN r = 0 THEN true ELSE pg_has_role(r, 'USAGE') END)
+	) pol ON true
+	WHERE c.oid = to_regclass($1)`
+
+// GetAllowedMethods returns the http methods the current role can use on a table or a view
+func GetAllowedMethods(ctx context.Context, sourcename string) ([]string, error) {
+	conn, schemaname := GetConnAndSchema(ctx)
+	var canSelect, canInsert, canUpdate, canDelete bool
+	err := conn.QueryRow(ctx, allowedCommandsQuery, _sq(sourcename, schemaname)).
+		Scan(&canSelect, &canInsert, &canUpdate, &canDelete)
+	if err != nil {
+		return nil, err
+	}
+	methods := []string{"OPTIONS"}
+	if canSelect {
+		methods = append(methods, "GET")
+	}
+	if canInsert {
+		methods = append(methods, "POST")
+	}
+	// PUT is an upsert by primary key
+	if canInsert && canUpdate {
+		info := GetSmoothContext(ctx).Db.info.Load()
+		if info.GetPrimaryKey(_s(sourcename, schemaname)) != nil {
+			methods = append(methods, "PUT")
+		}
+	}
+	if canUpdate {
+		methods = append(methods, "PATCH")
+	}
+	if canDelete {
+		methods = append(methods, "DELETE")
+	}
+	return methods, nil
+}
+
+// GetFunctionAllowedMethods returns the http methods the current role can use on a function
+func GetFunctionAllowedMethods(ctx context.Context, fname string) ([]string, error) {
+	conn, schemaname := GetConnAndSchema(ctx)
+	var canExecute *bool
+	err := conn.QueryRow(ctx, `
+		SELECT bool_or(has_function_privilege(p.oid, 'EXECUTE'))
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.proname = $1 AND n.nspname = $2`, fname, schemaname).Scan(&canExecute)
+	if err != nil {
+		return nil, err
+	}
+	if canExecute == nil {
+		// no function with this name
+		return nil, pgx.ErrNoRows
+	}
+	methods := []string{"OPTIONS"}
+	if *canExecute {
+		methods = append(methods, "GET", "POST")
+	}
+	return methods, nil
+}
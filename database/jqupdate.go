@@ -5,6 +5,7 @@ import (
 	"context"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/sted/smoothdb/jqeval"
 )
@@ -33,13 +34,15 @@ func jqParseArgs(raw string) (map[string]any, error) {
 // JQTransformResponse applies the jq program carried by the jq= query
 // parameter (see QueryOptions) to a fully buffered JSON response body,
 // binding jq_args as variables. It returns the body unchanged when no
-// program is present. Only JSON content types can be transformed.
+// program is present or when it is an execution plan. Only JSON content
+// types can be transformed.
 func JQTransformResponse(ctx context.Context, data []byte) ([]byte, error) {
 	gi := GetSmoothContext(ctx)
 	options := &gi.QueryOptions
-	if options.JQ == "" || data == nil {
+	if options.JQ == "" || data == nil || options.Plan != "" {
 		return data, nil
 	}
+	defer gi.track("jq", time.Now())
 	if !jqeval.Enabled() {
 		return nil, &ParseError{"jq evaluation is disabled (see the JQ configuration section)"}
 	}
@@ -82,6 +85,9 @@ func JQTransformResponse(ctx context.Context, data []byte) ([]byte, error) {
 func UpdateRecordsWithJQ(ctx context.Context, table string, filters Filters) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
 	options := &gi.QueryOptions
+	if options.preferenceError != nil {
+		return nil, 0, options.preferenceError
+	}
 	if !jqeval.Enabled() {
 		return nil, 0, &ParseError{"jq evaluation is disabled (see the JQ configuration section)"}
 	}
@@ -94,7 +100,9 @@ func UpdateRecordsWithJQ(ctx context.Context, table string, filters Filters) ([]
 	if err := jqeval.Parse(program, args); err != nil {
 		return nil, 0, err
 	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -104,6 +112,11 @@ func UpdateRecordsWithJQ(ctx context.Context, table string, filters Filters) ([]
 	schema := options.Schema
 	stack := BuildStack{info: gi.Db.info.Load()}
 	where, values := whereClause(table, schema, "", parts.whereConditionsTree, 0, stack)
+	if where == "" {
+		if err := checkUnfilteredWrite("UPDATE", options); err != nil {
+			return nil, 0, err
+		}
+	}
 
 	maxRows := jqeval.MaxUpdateRows()
 	query := "SELECT " + quote(table) + ".ctid::text, to_jsonb(" + quote(table) + ") FROM " + _sq(table, schema)
@@ -144,6 +157,10 @@ func UpdateRecordsWithJQ(ctx context.Context, table string, filters Filters) ([]
 		}
 	}
 
+	if err = checkMaxAffected(options, int64(len(ctids))); err != nil {
+		return nil, 0, err
+	}
+
 	if ownTx {
 		if _, err = gi.Conn.Exec(ctx, "COMMIT"); err != nil {
 			return nil, 0, err
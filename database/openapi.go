@@ -0,0 +1,425 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/sted/smoothdb/version"
+)
+
+// OpenAPI is an OpenAPI 3.1 document describing the sources and the functions
+// of a schema, as seen by the requesting role
+type OpenAPI struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIPathItem maps the http methods (get, post, ...) to the operations
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	Tags        []string                   `json:"tags,omitempty"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Ref         string         `json:"$ref,omitempty"`
+	Name        string         `json:"name,omitempty"`
+	In          string         `json:"in,omitempty"` // query, header
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is the subset of JSON Schema used to describe records and values
+type OpenAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        any                       `json:"type,omitempty"` // a type, or [type, "null"] when nullable
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Items       *OpenAPISchema            `json:"items,omitempty"`
+	OneOf       []*OpenAPISchema          `json:"oneOf,omitempty"`
+	Properties  map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+	PrimaryKey  bool                      `json:"x-primary-key,omitempty"`
+	ForeignKey  string                    `json:"x-foreign-key,omitempty"` // related table.column
+}
+
+type OpenAPIComponents struct {
+	Schemas    map[string]*OpenAPISchema   `json:"schemas"`
+	Parameters map[string]OpenAPIParameter `json:"parameters"`
+}
+
+// openAPISource is a table or a view with the privileges of the current role
+type openAPISource struct {
+	name      string
+	comment   *string
+	canSelect bool
+	canInsert bool
+	canUpdate bool
+	canDelete bool
+}
+
+// openAPIColumn is a column visible to the current role
+type openAPIColumn struct {
+	Column
+	generated bool // identity or generated column, it has a value when omitted
+}
+
+// column privileges are enough to show a source, as information_schema.columns
+// lists only the columns the role can use
+const openAPISourcesQuery = `
+	SELECT c.relname, obj_description(c.oid, 'pg_class'),
+		has_any_column_privilege(c.oid, 'SELECT'),
+		has_any_column_privilege(c.oid, 'INSERT'),
+		has_any_column_privilege(c.oid, 'UPDATE'),
+		has_table_privilege(c.oid, 'DELETE')
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = $1 AND c.relkind IN ('r', 'v', 'm', 'f', 'p') AND NOT c.relispartition
+	ORDER BY 1`
+
+const openAPIColumnsQuery = `
+	SELECT c.table_name, c.column_name, c.udt_name, c.is_nullable = 'NO', c.column_default,
+		col_description((quote_ident(c.table_schema) || '.' || quote_ident(c.table_name))::regclass, c.ordinal_position),
+		c.is_identity = 'YES' OR c.is_generated = 'ALWAYS'
+	FROM information_schema.columns c
+	WHERE c.table_schema = $1
+	ORDER BY c.table_name, c.ordinal_position`
+
+const openAPIFunctionsQuery = `
+	SELECT DISTINCT p.proname
+	FROM pg_proc p
+	JOIN pg_namespace n ON n.oid = p.pronamespace
+	WHERE n.nspname = $1 AND has_function_privilege(p.oid, 'EXECUTE')`
+
+// GetOpenAPI returns the OpenAPI document of the current schema, limited to the sources
+// and the functions the current role is granted
+func GetOpenAPI(ctx context.Context) (*OpenAPI, error) {
+	gi := GetSmoothContext(ctx)
+	conn, schema := gi.Conn, gi.QueryOptions.Schema
+
+	sources := []openAPISource{}
+	rows, err := conn.Query(ctx, openAPISourcesQuery, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s openAPISource
+		if err := rows.Scan(&s.name, &s.comment, &s.canSelect, &s.canInsert, &s.canUpdate, &s.canDelete); err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	columns := []openAPIColumn{}
+	rows, err = conn.Query(ctx, openAPIColumnsQuery, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c openAPIColumn
+		if err := rows.Scan(&c.Table, &c.Name, &c.Type, &c.NotNull, &c.Default, &c.Comment, &c.generated); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	functions := map[string]struct{}{}
+	rows, err = conn.Query(ctx, openAPIFunctionsQuery, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		functions[name] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildOpenAPI(gi.Db.Name, schema, sources, columns, functions, gi.Db.info.Load()), nil
+}
+
+// openAPIParameters are the parameters shared by the operations, in components
+var openAPIParameters = map[string]OpenAPIParameter{
+	"select": {Name: "select", In: "query", Description: "Columns and embedded resources to return, eg. id,name,rel(*)",
+		Schema: &OpenAPISchema{Type: "string"}},
+	"order": {Name: "order", In: "query", Description: "Ordering, eg. created.desc,id",
+		Schema: &OpenAPISchema{Type: "string"}},
+	"limit": {Name: "limit", In: "query", Description: "Maximum number of rows",
+		Schema: &OpenAPISchema{Type: "integer"}},
+	"offset": {Name: "offset", In: "query", Description: "Number of rows to skip",
+		Schema: &OpenAPISchema{Type: "integer"}},
+	"after": {Name: "after", In: "query", Description: "Keyset pagination: empty for the first page, then the Next-Cursor of the previous one",
+		Schema: &OpenAPISchema{Type: "string"}},
+	"range": {Name: "Range", In: "header", Description: "Rows to return, eg. 0-24",
+		Schema: &OpenAPISchema{Type: "string"}},
+	"rangeUnit": {Name: "Range-Unit", In: "header",
+		Schema: &OpenAPISchema{Type: "string", Enum: []string{"items"}}},
+	"preferCount": {Name: "Prefer", In: "header", Description: "Total of the rows in Content-Range",
+		Schema: &OpenAPISchema{Type: "string", Enum: []string{"count=exact", "count=planned", "count=estimated"}}},
+	"preferReturn": {Name: "Prefer", In: "header", Description: "Whether to return the affected rows",
+		Schema: &OpenAPISchema{Type: "string", Enum: []string{"return=minimal", "return=representation"}}},
+	"preferParams": {Name: "Prefer", In: "header", Description: "Pass the body as a single json argument",
+		Schema: &OpenAPISchema{Type: "string", Enum: []string{"params=single-object"}}},
+}
+
+func openAPIParameterRef(name string) OpenAPIParameter {
+	return OpenAPIParameter{Ref: "#/components/parameters/" + name}
+}
+
+func openAPISchemaRef(name string) *OpenAPISchema {
+	return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+func openAPIJSON(schema *OpenAPISchema) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// openAPIType maps a PostgreSQL type, either a udt name (int4, _text) or a regtype
+// name (integer, text[]), to a JSON schema
+func openAPIType(pgtype string) *OpenAPISchema {
+	if strings.HasPrefix(pgtype, "_") {
+		return &OpenAPISchema{Type: "array", Items: openAPIType(pgtype[1:])}
+	}
+	if strings.HasSuffix(pgtype, "[]") {
+		return &OpenAPISchema{Type: "array", Items: openAPIType(strings.TrimSuffix(pgtype, "[]"))}
+	}
+	schema := &OpenAPISchema{Format: pgtype}
+	switch pgtype {
+	case "int2", "int4", "int8", "smallint", "integer", "bigint":
+		schema.Type = "integer"
+	case "float4", "float8", "numeric", "real", "double precision":
+		schema.Type = "number"
+	case "bool", "boolean":
+		schema.Type = "boolean"
+	case "json", "jsonb":
+		// any json value
+	default:
+		schema.Type = "string"
+	}
+	return schema
+}
+
+// openAPIFilters returns a query parameter for the filters on each column
+func openAPIFilters(columns []openAPIColumn) []OpenAPIParameter {
+	params := []OpenAPIParameter{}
+	for _, c := range columns {
+		if _, reserved := postgRestReservedWords[c.Name]; reserved {
+			continue
+		}
+		params = append(params, OpenAPIParameter{Name: c.Name, In: "query",
+			Description: "Filter, eg. eq.value", Schema: &OpenAPISchema{Type: "string"}})
+	}
+	return params
+}
+
+func buildOpenAPI(title, schema string, sources []openAPISource, columns []openAPIColumn,
+	functions map[string]struct{}, info *SchemaInfo) *OpenAPI {
+
+	doc := &OpenAPI{
+		OpenAPI: "3.1.0",
+		Info:    OpenAPIInfo{Title: title, Version: version.Version},
+		Paths:   map[string]OpenAPIPathItem{},
+		Components: OpenAPIComponents{
+			Schemas:    map[string]*OpenAPISchema{},
+			Parameters: openAPIParameters,
+		},
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = "dev"
+	}
+	sourceColumns := map[string][]openAPIColumn{}
+	for _, c := range columns {
+		sourceColumns[c.Table] = append(sourceColumns[c.Table], c)
+	}
+
+	// SOURCES
+	for _, s := range sources {
+		cols := sourceColumns[s.name]
+		if len(cols) == 0 || !s.canSelect && !s.canInsert && !s.canUpdate && !s.canDelete {
+			continue
+		}
+		ftable := _s(s.name, schema)
+		record := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+		if s.comment != nil {
+			record.Description = *s.comment
+		}
+		var pk []string
+		if c := info.GetPrimaryKey(ftable); c != nil {
+			pk = c.Columns
+		}
+		fks := map[string]string{}
+		for _, fk := range info.GetForeignKeys(ftable) {
+			if len(fk.Columns) == 1 {
+				fks[fk.Columns[0]] = fk.RelatedTable + "." + fk.RelatedColumns[0]
+			}
+		}
+		for _, c := range cols {
+			prop := openAPIType(c.Type)
+			if !c.NotNull && prop.Type != nil {
+				prop.Type = []string{prop.Type.(string), "null"}
+			}
+			if c.Comment != nil {
+				prop.Description = *c.Comment
+			}
+			prop.PrimaryKey = lo.Contains(pk, c.Name)
+			prop.ForeignKey = fks[c.Name]
+			record.Properties[c.Name] = prop
+			if c.NotNull && c.Default == nil && !c.generated {
+				record.Required = append(record.Required, c.Name)
+			}
+		}
+		doc.Components.Schemas[s.name] = record
+
+		item := OpenAPIPathItem{}
+		filters := openAPIFilters(cols)
+		tags := []string{s.name}
+		if s.canSelect {
+			item["get"] = &OpenAPIOperation{
+				Tags: tags, Summary: "Read " + s.name,
+				Parameters: append([]OpenAPIParameter{
+					openAPIParameterRef("select"), openAPIParameterRef("order"),
+					openAPIParameterRef("limit"), openAPIParameterRef("offset"), openAPIParameterRef("after"),
+					openAPIParameterRef("range"), openAPIParameterRef("rangeUnit"), openAPIParameterRef("preferCount"),
+				}, filters...),
+				Responses: map[string]OpenAPIResponse{
+					"200": {Description: "OK", Content: openAPIJSON(&OpenAPISchema{Type: "array", Items: openAPISchemaRef(s.name)})},
+					"206": {Description: "Partial Content"},
+				},
+			}
+		}
+		if s.canInsert {
+			item["post"] = &OpenAPIOperation{
+				Tags: tags, Summary: "Create " + s.name,
+				Parameters: []OpenAPIParameter{openAPIParameterRef("select"), openAPIParameterRef("preferReturn")},
+				RequestBody: &OpenAPIRequestBody{Required: true, Content: openAPIJSON(&OpenAPISchema{
+					OneOf: []*OpenAPISchema{openAPISchemaRef(s.name), {Type: "array", Items: openAPISchemaRef(s.name)}}})},
+				Responses: map[string]OpenAPIResponse{
+					"201": {Description: "Created"},
+				},
+			}
+		}
+		if s.canUpdate {
+			item["patch"] = &OpenAPIOperation{
+				Tags: tags, Summary: "Update " + s.name,
+				Parameters: append([]OpenAPIParameter{
+					openAPIParameterRef("select"), openAPIParameterRef("preferReturn"),
+				}, filters...),
+				RequestBody: &OpenAPIRequestBody{Required: true, Content: openAPIJSON(openAPISchemaRef(s.name))},
+				Responses: map[string]OpenAPIResponse{
+					"200": {Description: "OK"},
+					"204": {Description: "No Content"},
+				},
+			}
+		}
+		if s.canDelete {
+			item["delete"] = &OpenAPIOperation{
+				Tags: tags, Summary: "Delete " + s.name,
+				Parameters: append([]OpenAPIParameter{
+					openAPIParameterRef("select"), openAPIParameterRef("preferReturn"),
+				}, filters...),
+				Responses: map[string]OpenAPIResponse{
+					"200": {Description: "OK"},
+					"204": {Description: "No Content"},
+				},
+			}
+		}
+		doc.Paths["/"+s.name] = item
+	}
+
+	// FUNCTIONS
+	names := []string{}
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := info.GetFunction(_s(name, schema))
+		if f == nil {
+			// not cached, it has unnamed arguments
+			continue
+		}
+		args := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+		params := []OpenAPIParameter{}
+		for _, arg := range f.Arguments {
+			if arg.Mode != 0 && arg.Mode != 'i' && arg.Mode != 'b' && arg.Mode != 'v' {
+				continue
+			}
+			argSchema := openAPIType(arg.Type)
+			args.Properties[arg.Name] = argSchema
+			params = append(params, OpenAPIParameter{Name: arg.Name, In: "query", Schema: argSchema})
+		}
+		var result *OpenAPISchema
+		if rettype := info.GetTypeById(f.ReturnTypeId); rettype != nil && rettype.IsTable && rettype.Schema == schema {
+			result = openAPISchemaRef(rettype.Name)
+		} else if rettype != nil && (rettype.IsTable || rettype.IsComposite) || f.HasOut {
+			result = &OpenAPISchema{Type: "object"}
+		} else {
+			result = openAPIType(f.Returns)
+		}
+		if f.ReturnIsSet {
+			result = &OpenAPISchema{Type: "array", Items: result}
+		}
+		responses := map[string]OpenAPIResponse{"200": {Description: "OK", Content: openAPIJSON(result)}}
+		if f.Returns == "void" {
+			responses = map[string]OpenAPIResponse{"204": {Description: "No Content"}}
+		}
+		tags := []string{"rpc"}
+		doc.Paths["/rpc/"+name] = OpenAPIPathItem{
+			"get": &OpenAPIOperation{
+				Tags: tags, Summary: "Call " + name,
+				Parameters: params, Responses: responses,
+			},
+			"post": &OpenAPIOperation{
+				Tags: tags, Summary: "Call " + name,
+				Parameters:  []OpenAPIParameter{openAPIParameterRef("preferParams")},
+				RequestBody: &OpenAPIRequestBody{Content: openAPIJSON(args)},
+				Responses:   responses,
+			},
+		}
+	}
+	return doc
+}
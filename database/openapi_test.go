@@ -0,0 +1,111 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildOpenAPI(t *testing.T) {
+	comment := "The orders"
+	info := &SchemaInfo{
+		cachedPrimaryKeys: map[string]Constraint{
+			"public.orders": {Columns: []string{"id"}},
+		},
+		cachedForeignKeys: map[string][]ForeignKey{
+			"public.orders": {{Columns: []string{"client_id"}, RelatedTable: "clients", RelatedColumns: []string{"id"}}},
+		},
+		cachedTypes: map[uint32]Type{
+			100: {Id: 100, Name: "orders", Schema: "public", IsTable: true},
+			23:  {Id: 23, Name: "int4", Schema: "pg_catalog"},
+		},
+		cachedFunctions: map[string]Function{
+			"public.open_orders": {Name: "open_orders", Schema: "public", Returns: "orders", ReturnTypeId: 100, ReturnIsSet: true,
+				Arguments: []Argument{{Name: "since", Type: "date", Mode: 'i'}}},
+			"public.add": {Name: "add", Schema: "public", Returns: "integer", ReturnTypeId: 23,
+				Arguments: []Argument{{Name: "a", Type: "integer"}, {Name: "b", Type: "integer"}}},
+			"public.secret": {Name: "secret", Schema: "public", Returns: "void"},
+		},
+	}
+	sources := []openAPISource{
+		{name: "orders", comment: &comment, canSelect: true, canInsert: true},
+		{name: "clients", canSelect: true},
+		{name: "hidden"},
+	}
+	defaultValue := "nextval('orders_id_seq')"
+	columns := []openAPIColumn{
+		{Column: Column{Table: "orders", Name: "id", Type: "int4", NotNull: true, Default: &defaultValue}},
+		{Column: Column{Table: "orders", Name: "client_id", Type: "int4", NotNull: true}},
+		{Column: Column{Table: "orders", Name: "tags", Type: "_text"}},
+		{Column: Column{Table: "orders", Name: "data", Type: "jsonb"}},
+		{Column: Column{Table: "orders", Name: "total", Type: "numeric", NotNull: true}, generated: true},
+		{Column: Column{Table: "clients", Name: "id", Type: "int4", NotNull: true}},
+		{Column: Column{Table: "hidden", Name: "id", Type: "int4", NotNull: true}},
+	}
+	// secret is not granted
+	functions := map[string]struct{}{"open_orders": {}, "add": {}}
+
+	doc := buildOpenAPI("testdb", "public", sources, columns, functions, info)
+
+	if doc.OpenAPI != "3.1.0" || doc.Info.Title != "testdb" {
+		t.Errorf("unexpected header %v %v", doc.OpenAPI, doc.Info)
+	}
+	paths := []string{}
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	for _, p := range []string{"/orders", "/clients", "/rpc/open_orders", "/rpc/add"} {
+		if _, ok := doc.Paths[p]; !ok {
+			t.Errorf("missing path %s in %v", p, paths)
+		}
+	}
+	if len(doc.Paths) != 4 {
+		t.Errorf("expected 4 paths, got %v", paths)
+	}
+	// operations follow the privileges
+	if orders := doc.Paths["/orders"]; orders["get"] == nil || orders["post"] == nil || orders["patch"] != nil || orders["delete"] != nil {
+		t.Errorf("unexpected operations on orders: %v", orders)
+	}
+	if clients := doc.Paths["/clients"]; clients["get"] == nil || clients["post"] != nil {
+		t.Errorf("unexpected operations on clients: %v", clients)
+	}
+
+	orders := doc.Components.Schemas["orders"]
+	if orders.Description != comment {
+		t.Errorf("expected description %q, got %q", comment, orders.Description)
+	}
+	if !reflect.DeepEqual(orders.Required, []string{"client_id"}) {
+		t.Errorf("expected required [client_id], got %v", orders.Required)
+	}
+	id := orders.Properties["id"]
+	if id.Type != "integer" || !id.PrimaryKey {
+		t.Errorf("unexpected id property %+v", id)
+	}
+	if fk := orders.Properties["client_id"].ForeignKey; fk != "clients.id" {
+		t.Errorf("expected foreign key clients.id, got %q", fk)
+	}
+	tags := orders.Properties["tags"]
+	if !reflect.DeepEqual(tags.Type, []string{"array", "null"}) || tags.Items.Type != "string" {
+		t.Errorf("unexpected tags property %+v", tags)
+	}
+	if data := orders.Properties["data"]; data.Type != nil {
+		t.Errorf("expected any type for jsonb, got %v", data.Type)
+	}
+
+	// functions
+	openOrders := doc.Paths["/rpc/open_orders"]["get"]
+	result := openOrders.Responses["200"].Content["application/json"].Schema
+	if result.Type != "array" || result.Items.Ref != "#/components/schemas/orders" {
+		t.Errorf("unexpected result of open_orders %+v", result)
+	}
+	if len(openOrders.Parameters) != 1 || openOrders.Parameters[0].Name != "since" {
+		t.Errorf("unexpected parameters of open_orders %+v", openOrders.Parameters)
+	}
+	add := doc.Paths["/rpc/add"]["post"]
+	args := add.RequestBody.Content["application/json"].Schema
+	if len(args.Properties) != 2 || args.Properties["a"].Type != "integer" {
+		t.Errorf("unexpected arguments of add %+v", args)
+	}
+	if add.Responses["200"].Content["application/json"].Schema.Type != "integer" {
+		t.Errorf("unexpected result of add %+v", add.Responses)
+	}
+}
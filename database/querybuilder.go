@@ -1,6 +1,10 @@
 package database
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
@@ -13,6 +17,7 @@ type QueryBuilder interface {
 	BuildInsert(table string, records []Record, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error)
 	BuildUpdate(table string, record Record, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error)
 	BuildDelete(table string, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error)
+	BuildUpsert(table string, record Record, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error)
 	BuildExecute(table string, record Record, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error)
 
 	preferredSerializer() TextSerializer
@@ -29,6 +34,10 @@ type Join struct {
 	relLabel     string        // label for the relationship, taken from the select clause
 	relName      string        // relation/function name as it appears in the query
 	selectFields []SelectField // inner select fields (for related order validation)
+	limit        string        // limit for the related rows (to-many only)
+	offset       string        // offset for the related rows (to-many only)
+	spreadNames  []string      // columns of a spread to-many relationship, aggregated into arrays
+	nullFilter   bool          // a null filter (rel=is.null) tests the existence of the related rows
 }
 
 type BuildError struct {
@@ -37,6 +46,14 @@ type BuildError struct {
 
 func (e BuildError) Error() string { return e.msg }
 
+// MethodError is returned when the request doesn't satisfy the conditions of its
+// method, like the primary key filters of PUT
+type MethodError struct {
+	msg string // description of error
+}
+
+func (e MethodError) Error() string { return e.msg }
+
 // BuildStack represents the context when navigating the AST produced by the parser
 type BuildStack struct {
 	info            *SchemaInfo // database information (tables, contraints, etc)
@@ -45,6 +62,7 @@ type BuildStack struct {
 	labelPath       []string    // sequence of nested labels for the correspondent tables (can contain empty strings)
 	colPath         []string    // sequence of FK column names that point to the correspondent tables (can contain empty strings)
 	afterWithClause bool        //
+	junction        string      // junction table of the M2M relationship that leads to this level, if any
 }
 
 // nextBuildStack creates a stack with a new level
@@ -52,7 +70,7 @@ func nextBuildStack(stack BuildStack, rel string, label string, col string) Buil
 	relPath := append(stack.relPath, rel)
 	labelPath := append(stack.labelPath, label)
 	colPath := append(stack.colPath, col)
-	return BuildStack{stack.info, stack.level + 1, relPath, labelPath, colPath, false}
+	return BuildStack{stack.info, stack.level + 1, relPath, labelPath, colPath, false, ""}
 }
 
 // toJson wraps a field into a to_jsonb operator if its type is array or composite,
@@ -69,16 +87,49 @@ func toJson(table, schema, field, quotedField string, info *SchemaInfo) string {
 	return quotedField
 }
 
+// computedField returns the call to the function implementing a computed field of the table
+// (eg. full_name(people) for people.full_name), or "" if name is not a computed field.
+// The function must be in the schema of the request, and columns take precedence over functions
+// with the same name. rowRef is the reference to the current row of the table.
+func computedField(table, schema, name, rowRef string, info *SchemaInfo) string {
+	if info == nil || isStar(name) {
+		return ""
+	}
+	ftable := _s(table, schema)
+	if info.GetColumnType(ftable, name) != nil {
+		return ""
+	}
+	f := info.GetComputedField(ftable, _s(name, schema))
+	if f == nil {
+		return ""
+	}
+	return _sq(f.Name, f.Schema) + "(" + rowRef + "::" + quoteParts(ftable) + ")"
+}
+
 func prepareField(table, schema string, sfield SelectField, info *SchemaInfo) string {
+	return prepareFieldRef(_sq(table, schema)+"."+quoteIf(sfield.field.name, !isStar(sfield.field.name)), table, schema, sfield, info)
+}
+
+// prepareFieldRef is like prepareField, with an explicit reference to the field (eg. a computed field)
+func prepareFieldRef(ref, table, schema string, sfield SelectField, info *SchemaInfo) string {
 	var fieldPart string
 
 	if sfield.aggregate == "" {
 		// Regular field without aggregate
-		fieldname := _sq(table, schema) + "." + quoteIf(sfield.field.name, !isStar(sfield.field.name))
+		fieldname := ref
 		if sfield.field.jsonPath != "" {
 			fieldname = toJson(table, schema, sfield.field.name, fieldname, info)
 			fieldname = "(" + fieldname + sfield.field.jsonPath + ")"
 		}
+		if sfield.distance != "" {
+			fieldname = "(" + fieldname + " " + sfield.distance + " " + vectorLiteral(table, schema, sfield.field.name, sfield.vector, info) + ")"
+		}
+		if sfield.textSearch != nil {
+			fieldname = textSearchExpression(table, schema, sfield.field.name, fieldname, sfield.textSearch, info)
+		}
+		if sfield.similar != "" {
+			fieldname = "similarity(" + fieldname + ", " + quoteLit(sfield.similar) + ")"
+		}
 		// Apply field cast for regular fields (no extra parentheses needed)
 		if sfield.cast != "" {
 			fieldname = fieldname + "::" + sfield.cast
@@ -90,7 +141,7 @@ func prepareField(table, schema string, sfield SelectField, info *SchemaInfo) st
 			fieldPart = "COUNT(*)"
 		} else {
 			// For aggregates with specific fields
-			fieldname := _sq(table, schema) + "." + quoteIf(sfield.field.name, !isStar(sfield.field.name))
+			fieldname := ref
 			if sfield.field.jsonPath != "" {
 				fieldname = toJson(table, schema, sfield.field.name, fieldname, info)
 				fieldname = "(" + fieldname + sfield.field.jsonPath + ")"
@@ -104,7 +155,7 @@ func prepareField(table, schema string, sfield SelectField, info *SchemaInfo) st
 					fieldname = "(" + fieldname + ")::" + sfield.cast
 				}
 			}
-			fieldPart = sfield.aggregate + "(" + fieldname + ")"
+			fieldPart = aggregateExpression(sfield.aggregate, sfield.aggArg, fieldname)
 		}
 		// Apply aggregate cast after aggregation
 		if sfield.aggCast != "" {
@@ -118,6 +169,65 @@ func prepareField(table, schema string, sfield SelectField, info *SchemaInfo) st
 	return fieldPart
 }
 
+// ftsQueryFunctions build the tsquery of the FTS operators
+var ftsQueryFunctions = map[string]string{
+	"fts":   "to_tsquery",
+	"plfts": "plainto_tsquery",
+	"phfts": "phraseto_tsquery",
+	"wfts":  "websearch_to_tsquery",
+}
+
+// textSearchExpression ranks the field, or highlights it with ts_headline, for the
+// query in ts. Like the FTS filters, a column that is not a tsvector is ranked
+// with to_tsvector.
+func textSearchExpression(table, schema, name, fieldname string, ts *TextSearch, info *SchemaInfo) string {
+	query := ftsQueryFunctions[ts.operator] + "("
+	lang := "'english'"
+	if ts.language != "" {
+		lang = quoteLit(ts.language)
+		query += lang + ", "
+	}
+	query += quoteLit(ts.query) + ")"
+	if ts.function == "ts_headline" {
+		if ts.language != "" {
+			fieldname = lang + ", " + fieldname
+		}
+		return "ts_headline(" + fieldname + ", " + query + ")"
+	}
+	if info != nil {
+		ct := info.GetColumnType(_s(table, schema), name)
+		if ct != nil && ct.Type != "tsvector" {
+			fieldname = "to_tsvector(" + lang + ", " + fieldname + ")"
+		}
+	}
+	return ts.function + "(" + fieldname + ", " + query + ")"
+}
+
+// vectorLiteral returns the vector of a pgvector distance as a literal of the type of the column,
+// so that a value that is not a vector is rejected by the database
+func vectorLiteral(table, schema, field, vector string, info *SchemaInfo) string {
+	typ := "vector"
+	if info != nil {
+		if ct := info.GetColumnType(_s(table, schema), field); ct != nil && ct.Type == "halfvec" {
+			typ = "halfvec"
+		}
+	}
+	return quoteLit(vector) + "::" + typ
+}
+
+// aggregateExpression applies an aggregate function to an expression.
+// The argument has been validated by the parser.
+func aggregateExpression(aggregate, arg, expr string) string {
+	switch aggregate {
+	case "STRING_AGG":
+		return "STRING_AGG((" + expr + ")::text, " + quoteLit(arg) + ")"
+	case "PERCENTILE_CONT", "PERCENTILE_DISC":
+		return aggregate + "(" + arg + ") WITHIN GROUP (ORDER BY " + expr + ")"
+	default:
+		return aggregate + "(" + expr + ")"
+	}
+}
+
 func labelWithNumber(table string, num int) string {
 	return table + "_" + strconv.Itoa(num)
 }
@@ -168,6 +278,9 @@ func selectForJoinClause(join Join, label string, parts *QueryParts, stack Build
 		if wc != "" {
 			sel += " WHERE " + wc
 		}
+		if gb := groupByClause(table, schema, label1, join.selectFields, stack.info); gb != "" {
+			sel += " GROUP BY " + gb
+		}
 		oc, err := orderClause(table, schema, label1, stack.level+1, parts.orderFields, join.selectFields, stack.info)
 		if err != nil {
 			return "", err
@@ -226,8 +339,9 @@ func selectForJoinClause(join Join, label string, parts *QueryParts, stack Build
 		// the external query are skipped inside the functions.
 		// If the internal table is equal to the external one we avoid repeating
 		// the expressions.
+		schema, table := splitTableName(rel.RelatedTable)
+		var oc string
 		if rel.Table != rel.RelatedTable {
-			schema, table := splitTableName(rel.RelatedTable)
 			col := ""
 			if len(rel.Columns) == 1 {
 				col = rel.Columns[0]
@@ -236,13 +350,26 @@ func selectForJoinClause(join Join, label string, parts *QueryParts, stack Build
 			if whereClause != "" {
 				sel += " AND " + whereClause
 			}
-			oc, err := orderClause(table, schema, label1, stack.level+1, parts.orderFields, join.selectFields, stack.info)
+			oc, err = orderClause(table, schema, label1, stack.level+1, parts.orderFields, join.selectFields, stack.info)
 			if err != nil {
 				return "", err
 			}
-			if oc != "" {
-				sel += " ORDER BY " + oc
-			}
+		}
+		// aggregates of the embedded resource, grouped by its other fields
+		if gb := groupByClause(table, schema, label1, join.selectFields, stack.info); gb != "" {
+			sel += " GROUP BY " + gb
+		}
+		if oc != "" {
+			sel += " ORDER BY " + oc
+		}
+	}
+	// limit and offset are validated as integers by the parser and only make sense for many rows
+	if rel.Type == O2M || rel.Type == M2M || rel.Type == Computed && rel.ReturnIsSet {
+		if join.limit != "" {
+			sel += " LIMIT " + join.limit
+		}
+		if join.offset != "" {
+			sel += " OFFSET " + join.offset
 		}
 	}
 	return sel, nil
@@ -291,9 +418,9 @@ func findRelationship(table, relation, fk, schema string, info *SchemaInfo) (rel
 
 // selectClause prepares the select clause of a query and builds the discovered joins.
 // The label parameter is used to construct a query for the execution of functions.
-// It
+// countJoins are the joins that restrict the rows, the only ones needed to count them.
 func selectClause(table, schema, label string, parts *QueryParts, stack BuildStack) (
-	selClause string, joins string, keys []string, err error) {
+	selClause string, joins string, countJoins string, keys []string, err error) {
 
 	var parentTable string
 	var relatedTable string
@@ -303,7 +430,26 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 	joinSeq := []Join{}
 
 	for i, sfield := range parts.selectFields {
+		junction := false
 		if sfield.relation != nil {
+			var err error
+			if junction, err = isJunction(sfield.relation, stack); err != nil {
+				return "", "", "", nil, err
+			}
+		}
+		if junction {
+			// columns of the junction table of the M2M relationship that leads here
+			if len(sfield.relation.fields) != 0 {
+				if i != 0 {
+					selClause += ", "
+				}
+				jc, err := junctionColumns(sfield, stack)
+				if err != nil {
+					return "", "", "", nil, err
+				}
+				selClause += jc
+			}
+		} else if sfield.relation != nil {
 			if sfield.relation.parent != "" {
 				parentTable = sfield.relation.parent
 			} else {
@@ -311,8 +457,9 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 			}
 			frel, err := findRelationship(parentTable, sfield.relation.name, sfield.relation.fk, schema, stack.info)
 			if err != nil {
-				return "", "", nil, err
+				return "", "", "", nil, err
 			}
+			var spreadNames []string
 			if sfield.label == "" {
 				labelRelName = sfield.relation.name
 			} else {
@@ -333,17 +480,25 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 					}
 				case O2M, M2M:
 					if sfield.relation.spread {
-						err = &BuildError{"A spread operation on " + relatedTable + " is not possible"}
-						return "", "", nil, err
+						spreadNames, err = spreadColumnNames(sfield.relation.fields)
+						if err != nil {
+							return "", "", "", nil, err
+						}
+						selClause += spreadArrays(joinName, spreadNames)
+					} else {
+						selClause += " COALESCE(" + quote(joinName) + ".\"_" + joinName + "\", '[]') AS " + quote(labelRelName)
 					}
-					selClause += " COALESCE(" + quote(joinName) + ".\"_" + joinName + "\", '[]') AS " + quote(labelRelName)
 				case Computed:
 					if frel.ReturnIsSet {
 						if sfield.relation.spread {
-							err = &BuildError{"A spread operation on " + sfield.relation.name + " is not possible"}
-							return "", "", nil, err
+							spreadNames, err = spreadColumnNames(sfield.relation.fields)
+							if err != nil {
+								return "", "", "", nil, err
+							}
+							selClause += spreadArrays(joinName, spreadNames)
+						} else {
+							selClause += " COALESCE(" + quote(joinName) + ".\"_" + joinName + "\", '[]') AS " + quote(labelRelName)
 						}
-						selClause += " COALESCE(" + quote(joinName) + ".\"_" + joinName + "\", '[]') AS " + quote(labelRelName)
 					} else {
 						if sfield.relation.spread {
 							selClause += quote(joinName) + ".*"
@@ -354,7 +509,7 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 				}
 			}
 			_, relatedTable = splitTableName(frel.RelatedTable)
-			internalParts := &QueryParts{selectFields: sfield.relation.fields, whereConditionsTree: parts.whereConditionsTree}
+			internalParts := &QueryParts{selectFields: sfield.relation.fields, orderFields: parts.orderFields, whereConditionsTree: parts.whereConditionsTree}
 			// For computed relationships, use the function name in the relPath so WHERE filters match
 			stackRelName := relatedTable
 			if frel.Type == Computed {
@@ -364,17 +519,28 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 			if frel.Type != Computed && len(frel.Columns) == 1 {
 				col = frel.Columns[0]
 			}
-			sc, j, _, err := selectClause(relatedTable, schema, "", internalParts, nextBuildStack(stack, stackRelName, sfield.label, col))
+			internalStack := nextBuildStack(stack, stackRelName, sfield.label, col)
+			if frel.Type == M2M {
+				internalStack.junction = frel.JunctionTable
+			}
+			sc, j, _, _, err := selectClause(relatedTable, schema, "", internalParts, internalStack)
 			if err != nil {
-				return "", "", nil, err
+				return "", "", "", nil, err
 			}
-			joinSeq = append(joinSeq, Join{joinName, sc, j, sfield.relation.inner, frel, sfield.label, sfield.relation.name, sfield.relation.fields})
+			joinSeq = append(joinSeq, Join{joinName, sc, j, sfield.relation.inner, frel, sfield.label, sfield.relation.name,
+				sfield.relation.fields, sfield.relation.limit, sfield.relation.offset, spreadNames,
+				hasNullFilter(parts.whereConditionsTree, &parts.selectFields[i])})
 		} else {
 			if i != 0 {
 				selClause += ", "
 			}
 			var fieldPart string
-			if !stack.afterWithClause {
+			if ref := computedField(table, schema, sfield.field.name, rowRef(table, label, stack), stack.info); ref != "" {
+				if sfield.label == "" {
+					sfield.label = sfield.field.name
+				}
+				fieldPart = prepareFieldRef(ref, table, schema, sfield, stack.info)
+			} else if !stack.afterWithClause {
 				if stack.level == 0 {
 					if label == "" {
 						fieldPart = prepareField(table, schema, sfield, stack.info)
@@ -398,37 +564,42 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 			relName := join.name
 			selectForJoin, err := selectForJoinClause(join, label, parts, stack)
 			if err != nil {
-				return "", "", nil, err
+				return "", "", "", nil, err
 			}
+			var joinClause string
 			if join.inner {
-				joins += " INNER"
+				joinClause += " INNER"
 			} else {
-				joins += " LEFT"
+				joinClause += " LEFT"
 			}
-			joins += " JOIN LATERAL ("
+			joinClause += " JOIN LATERAL ("
 			switch join.rel.Type {
 			case M2O, O2O:
-				joins += selectForJoin
+				joinClause += selectForJoin
 			case O2M, M2M:
-				joins += " SELECT json_agg(\"_" + relName + "\") AS \"_" + relName + "\""
-				joins += " FROM ("
-				joins += selectForJoin
-				joins += " ) AS \"_" + relName + "\""
+				joinClause += " SELECT " + aggregateForJoin(join)
+				joinClause += " FROM ("
+				joinClause += selectForJoin
+				joinClause += " ) AS \"_" + relName + "\""
 			case Computed:
 				if join.rel.ReturnIsSet {
-					joins += " SELECT json_agg(\"_" + relName + "\") AS \"_" + relName + "\""
-					joins += " FROM ("
-					joins += selectForJoin
-					joins += " ) AS \"_" + relName + "\""
+					joinClause += " SELECT " + aggregateForJoin(join)
+					joinClause += " FROM ("
+					joinClause += selectForJoin
+					joinClause += " ) AS \"_" + relName + "\""
 				} else {
-					joins += selectForJoin
+					joinClause += selectForJoin
 				}
 			}
-			joins += ") AS \"" + relName + "\" ON"
+			joinClause += ") AS \"" + relName + "\" ON"
 			if join.inner && (join.rel.Type == O2M || join.rel.Type == M2M || (join.rel.Type == Computed && join.rel.ReturnIsSet)) {
-				joins += " \"" + relName + "\" IS NOT NULL"
+				joinClause += " \"" + relName + "\" IS NOT NULL"
 			} else {
-				joins += " TRUE"
+				joinClause += " TRUE"
+			}
+			joins += joinClause
+			if join.inner || join.nullFilter {
+				countJoins += joinClause
 			}
 			// No FK columns needed for computed relationships
 			if join.rel.Type != Computed {
@@ -438,25 +609,167 @@ func selectClause(table, schema, label string, parts *QueryParts, stack BuildSta
 			}
 		}
 	}
-	return selClause, joins, keys, nil
+	return selClause, joins, countJoins, keys, nil
 }
 
-// groupByClause creates a GROUP BY clause when aggregate functions are present
-func groupByClause(table, schema string, parts *QueryParts, info *SchemaInfo) string {
+// rowRef returns the reference to the current row of table, to be passed to the functions
+// of computed fields
+func rowRef(table, label string, stack BuildStack) string {
+	switch {
+	case stack.afterWithClause:
+		return quote("_source")
+	case stack.level > 0:
+		return quote(labelWithNumber(table, stack.level))
+	case label != "":
+		return label
+	default:
+		return quote(table)
+	}
+}
+
+// aggregateForJoin aggregates the rows of a to-many join: into a single array of objects,
+// or, for a spread relationship, into an array for each column.
+func aggregateForJoin(join Join) string {
+	relName := join.name
+	if join.spreadNames == nil {
+		return "json_agg(\"_" + relName + "\") AS \"_" + relName + "\""
+	}
+	var agg string
+	for i, name := range join.spreadNames {
+		if i != 0 {
+			agg += ", "
+		}
+		agg += "json_agg(\"_" + relName + "\"." + quote(name) + ") AS " + quote(name)
+	}
+	return agg
+}
+
+// spreadArrays selects the arrays produced by a spread to-many join.
+// json_agg returns NULL when there are no rows, so that inner joins can test the join row.
+func spreadArrays(joinName string, names []string) string {
+	var sel string
+	for i, name := range names {
+		if i != 0 {
+			sel += ", "
+		}
+		sel += " COALESCE(" + quote(joinName) + "." + quote(name) + ", '[]') AS " + quote(name)
+	}
+	return sel
+}
+
+// spreadColumnNames returns the names of the columns produced by the fields of a spread relationship.
+// A spread to-many relationship needs them to aggregate each column, so '*' is not allowed.
+func spreadColumnNames(fields []SelectField) ([]string, error) {
+	var names []string
+	for _, sf := range fields {
+		if sf.relation != nil {
+			if sf.relation.spread {
+				nested, err := spreadColumnNames(sf.relation.fields)
+				if err != nil {
+					return nil, err
+				}
+				names = append(names, nested...)
+			} else if len(sf.relation.fields) != 0 {
+				if sf.label != "" {
+					names = append(names, sf.label)
+				} else {
+					names = append(names, sf.relation.name)
+				}
+			}
+		} else if isStar(sf.field.name) {
+			return nil, &BuildError{"A spread operation on a to-many relationship requires explicit columns"}
+		} else if sf.label != "" {
+			names = append(names, sf.label)
+		} else {
+			names = append(names, sf.field.name)
+		}
+	}
+	return names, nil
+}
+
+// isJunction returns true if the relation refers, with the junction hint, to the junction
+// table of the M2M relationship that leads to the current level, eg. users(name,project_users!junction(role))
+// embedded in projects. Without the hint, the junction table is embedded as any other table.
+func isJunction(rel *SelectRelation, stack BuildStack) (bool, error) {
+	if rel.fk != "junction" {
+		return false, nil
+	}
+	if stack.junction != "" {
+		if _, jtable := splitTableName(stack.junction); rel.name == jtable {
+			return true, nil
+		}
+	}
+	return false, &BuildError{"'" + rel.name + "' is not the junction table of a many-to-many relationship"}
+}
+
+// junctionColumns selects the columns of the junction table, that is already part of
+// the join with the related table: spread as plain columns or as a single object.
+func junctionColumns(sfield SelectField, stack BuildStack) (string, error) {
+	jschema, jtable := splitTableName(stack.junction)
+	var cols string
+	if sfield.relation.spread {
+		for i, f := range sfield.relation.fields {
+			if f.relation != nil {
+				return "", &BuildError{"cannot embed resources in the junction table " + jtable}
+			}
+			if i != 0 {
+				cols += ", "
+			}
+			cols += prepareField(jtable, jschema, f, stack.info)
+		}
+		return cols, nil
+	}
+	label := sfield.label
+	if label == "" {
+		label = sfield.relation.name
+	}
+	for _, f := range sfield.relation.fields {
+		if f.relation != nil {
+			return "", &BuildError{"cannot embed resources in the junction table " + jtable}
+		}
+		if isStar(f.field.name) {
+			return " row_to_json(" + _sq(jtable, jschema) + ".*) AS " + quote(label), nil
+		}
+	}
+	for i, f := range sfield.relation.fields {
+		if i != 0 {
+			cols += ", "
+		}
+		name := f.label
+		if name == "" {
+			name = f.field.name
+		}
+		f.label = ""
+		cols += quoteLit(name) + ", " + prepareField(jtable, jschema, f, stack.info)
+	}
+	return " json_build_object(" + cols + ") AS " + quote(label), nil
+}
+
+// groupByClause creates a GROUP BY clause when aggregate functions are present.
+// label is the alias of the table inside embedded resources, "" for the main table.
+func groupByClause(table, schema, label string, selectFields []SelectField, info *SchemaInfo) string {
 	// If no select fields are specified, no GROUP BY needed
-	if len(parts.selectFields) == 0 {
+	if len(selectFields) == 0 {
 		return ""
 	}
 
 	var hasAggregates bool
 	var nonAggregateFields []string
 
-	for _, sfield := range parts.selectFields {
+	for _, sfield := range selectFields {
 		if sfield.aggregate != "" {
 			hasAggregates = true
 		} else if sfield.relation == nil && sfield.field.name != "*" && sfield.field.name != "" && sfield.field.name != "," {
 			// Skip empty field names and comma separators
 			fieldname := _sq(table, schema) + "." + quote(sfield.field.name)
+			rowRef := quote(table)
+			if label != "" {
+				fieldname = label + "." + quote(sfield.field.name)
+				rowRef = label
+			}
+			if ref := computedField(table, schema, sfield.field.name, rowRef, info); ref != "" {
+				fieldname = ref
+			}
 			if sfield.field.jsonPath != "" {
 				fieldname = "(" + toJson(table, schema, sfield.field.name, fieldname, info) +
 					sfield.field.jsonPath + ")"
@@ -477,6 +790,22 @@ func groupByClause(table, schema string, parts *QueryParts, info *SchemaInfo) st
 	return ""
 }
 
+// distinctClause returns DISTINCT, or DISTINCT ON with the fields in parts.distinctFields
+func distinctClause(table, schema string, parts *QueryParts, info *SchemaInfo) (string, error) {
+	if !parts.distinct {
+		return "", nil
+	}
+	if len(parts.distinctFields) == 0 {
+		return "DISTINCT ", nil
+	}
+	// the fields are written like the order ones, without directions
+	fields, err := orderClause(table, schema, "", 0, parts.distinctFields, nil, info)
+	if err != nil {
+		return "", err
+	}
+	return "DISTINCT ON (" + fields + ") ", nil
+}
+
 func orderClause(table, schema, label string, level int, orderFields []OrderField,
 	selectFields []SelectField, info *SchemaInfo) (string, error) {
 	var order string
@@ -502,17 +831,33 @@ func orderClause(table, schema, label string, level int, orderFields []OrderFiel
 			}
 		} else if label == "" {
 			fieldname = _stq(o.field.name, schema, table)
+			if ref := computedField(table, schema, o.field.name, quote(table), info); ref != "" {
+				fieldname = ref
+			}
 			if o.field.jsonPath != "" {
 				fieldname = "(" + toJson(table, schema, o.field.name, fieldname, info) +
 					o.field.jsonPath + ")"
 			}
 		} else {
 			fieldname = label + "." + quote(o.field.name)
+			if ref := computedField(table, schema, o.field.name, label, info); ref != "" {
+				fieldname = ref
+			}
 			if o.field.jsonPath != "" {
 				fieldname = "(" + toJson(table, schema, o.field.name, fieldname, info) +
 					o.field.jsonPath + ")"
 			}
 		}
+		if o.distance != "" {
+			fieldname = "(" + fieldname + " " + o.distance + " " + vectorLiteral(table, schema, o.field.name, o.vector, info) + ")"
+		}
+		if o.textSearch != nil {
+			fieldname = textSearchExpression(table, schema, o.field.name, fieldname, o.textSearch, info)
+		}
+		if o.similar != "" {
+			// the trigram distance, 1 - similarity, can use a GiST index
+			fieldname = "(" + fieldname + " <-> " + quoteLit(o.similar) + ")"
+		}
 		order += fieldname
 		if o.descending {
 			order += " DESC"
@@ -646,16 +991,37 @@ func whereClause(table, schema, label string, node *WhereConditionNode, nmarker
 		if node.not {
 			where += "NOT "
 		}
+		if node.embed != nil {
+			where += embedNullCondition(table, schema, node, stack)
+			return where, valueList
+		}
 		var fieldname string
 		if stack.level == 0 {
 			fieldname = _stq(node.field.name, schema, table)
+			if ref := computedField(table, schema, node.field.name, quote(table), stack.info); ref != "" {
+				fieldname = ref
+			}
 		} else {
 			fieldname = quote(labelWithNumber(table, stack.level)) + "." + quote(node.field.name)
+			if ref := computedField(table, schema, node.field.name, quote(labelWithNumber(table, stack.level)), stack.info); ref != "" {
+				fieldname = ref
+			}
 		}
 		if node.field.jsonPath != "" {
 			fieldname = "(" + toJson(table, schema, node.field.name, fieldname, stack.info) +
 				node.field.jsonPath + ")"
 		}
+		if node.aggregate == "COUNT" && node.field.name == "*" {
+			fieldname = "COUNT(*)"
+		} else if node.aggregate != "" {
+			fieldname = aggregateExpression(node.aggregate, node.aggArg, fieldname)
+		}
+		if node.distance != "" {
+			// the compared value is the distance to the vector
+			fieldname = "(" + fieldname + " " + node.distance + " "
+			fieldname, valueList, nmarker = appendValue(fieldname, node.vector, valueList, nmarker, true)
+			fieldname += ")"
+		}
 		if node.opModifier != "" {
 			// any/all modifier: expand to (field OP v1 OR/AND field OP v2 ...)
 			var boolOp string
@@ -670,9 +1036,16 @@ func whereClause(table, schema, label string, node *WhereConditionNode, nmarker
 					where += boolOp
 				}
 				where += fieldname + " " + node.operator + " "
-				where, valueList, nmarker = appendValue(where, value, valueList, nmarker, node.field.jsonPath != "")
+				where, valueList, nmarker = appendValue(where, value, valueList, nmarker, node.field.jsonPath != "" || node.bound)
 			}
 			where += ")"
+		} else if _, ok := spatialOperators[node.opSource]; ok {
+			where, valueList = spatialCondition(where, fieldname, node, valueList, nmarker)
+		} else if _, ok := jsonPathOperators[node.opSource]; ok {
+			// the path is always a parameter, even when it is null or true
+			where += fieldname + " " + node.operator + " "
+			where, valueList, _ = appendValue(where, node.values[0], valueList, nmarker, true)
+			where += "::jsonpath"
 		} else {
 			where += fieldname
 			if node.operator == "IN" && len(node.values) == 0 {
@@ -700,20 +1073,11 @@ func whereClause(table, schema, label string, node *WhereConditionNode, nmarker
 					if i != 0 {
 						where += ", "
 					}
-					where, valueList, nmarker = appendValue(where, value, valueList, nmarker, node.field.jsonPath != "")
+					where, valueList, nmarker = appendValue(where, value, valueList, nmarker, node.field.jsonPath != "" || node.bound)
 				}
 				where += ")"
 			} else if node.operator == "@@" {
-				switch node.opSource {
-				case "fts":
-					where += "to_tsquery("
-				case "plfts":
-					where += "plainto_tsquery("
-				case "phfts":
-					where += "phraseto_tsquery("
-				case "wfts":
-					where += "websearch_to_tsquery("
-				}
+				where += ftsQueryFunctions[node.opSource] + "("
 				for _, arg := range node.opArgs {
 					where += quoteLit(arg)
 					where += ", "
@@ -722,13 +1086,142 @@ func whereClause(table, schema, label string, node *WhereConditionNode, nmarker
 				where += ")"
 
 			} else {
-				where, valueList, _ = appendValue(where, node.values[0], valueList, nmarker, node.field.jsonPath != "")
+				where, valueList, _ = appendValue(where, node.values[0], valueList, nmarker, node.field.jsonPath != "" || node.bound)
+				where += rangeOperandCast(table, schema, node, stack.info)
 			}
 		}
 	}
 	return where, valueList
 }
 
+// havingClause builds the conditions on the aggregates of the main table, with their
+// markers following the ones in valueList
+func havingClause(table, schema string, parts *QueryParts, valueList []any, stack BuildStack) (string, []any) {
+	having, havingValues := whereClause(table, schema, "", parts.havingConditionsTree, len(valueList), stack)
+	return having, append(valueList, havingValues...)
+}
+
+// spatialOperators are the PostGIS filters
+var spatialOperators = map[string]struct{}{
+	"intersects": {}, "dwithin": {}, "bbox": {},
+}
+
+// spatialGeometry returns the conversion to geometry of a spatial filter value,
+// GeoJSON or (E)WKT
+func spatialGeometry(where, value string, valueList []any, nmarker int) (string, []any, int) {
+	if strings.HasPrefix(value, "{") {
+		where += "ST_GeomFromGeoJSON("
+	} else {
+		where += "ST_GeomFromEWKT("
+	}
+	where, valueList, nmarker = appendValue(where, value, valueList, nmarker, true)
+	return where + ")", valueList, nmarker
+}
+
+// spatialCondition builds the condition of the spatial filters, validated by the parser:
+//   - geom=intersects.<geometry>: ST_Intersects(geom, <geometry>)
+//   - geom=dwithin(<distance>).<geometry>: ST_DWithin(geom, <geometry>, <distance>)
+//   - geom=bbox[(<srid>)].(<xmin>,<ymin>,<xmax>,<ymax>): geom && ST_MakeEnvelope(...), SRID 4326 by default
+func spatialCondition(where, fieldname string, node *WhereConditionNode, valueList []any, nmarker int) (string, []any) {
+	switch node.opSource {
+	case "intersects":
+		where += "ST_Intersects(" + fieldname + ", "
+		where, valueList, _ = spatialGeometry(where, node.values[0], valueList, nmarker)
+		where += ")"
+	case "dwithin":
+		where += "ST_DWithin(" + fieldname + ", "
+		where, valueList, nmarker = spatialGeometry(where, node.values[0], valueList, nmarker)
+		where += ", "
+		where, valueList, _ = appendValue(where, node.opArgs[0], valueList, nmarker, true)
+		where += ")"
+	case "bbox":
+		where += fieldname + " && ST_MakeEnvelope("
+		for _, value := range node.values {
+			where, valueList, nmarker = appendValue(where, value, valueList, nmarker, true)
+			where += ", "
+		}
+		srid := "4326"
+		if len(node.opArgs) != 0 {
+			srid = node.opArgs[0]
+		}
+		where, valueList, _ = appendValue(where, srid, valueList, nmarker, true)
+		where += ")"
+	}
+	return where, valueList
+}
+
+// rangeOperators are the operators shared by ranges and multiranges
+var rangeOperators = map[string]struct{}{
+	"@>": {}, "<@": {}, "&&": {}, "<<": {}, ">>": {}, "&<": {}, "&>": {}, "-|-": {},
+}
+
+// rangeOperandCast returns the cast to the range type for a range value compared with
+// a multirange column (eg. mr=ov.[1,3)), because PostgreSQL would read the value as
+// a multirange. It returns "" in the other cases.
+func rangeOperandCast(table, schema string, node *WhereConditionNode, info *SchemaInfo) string {
+	if info == nil || node.field.jsonPath != "" {
+		return ""
+	}
+	if _, ok := rangeOperators[node.operator]; !ok {
+		return ""
+	}
+	value := node.values[0]
+	if value != "empty" && !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "(") {
+		return ""
+	}
+	ct := info.GetColumnType(_s(table, schema), node.field.name)
+	if ct == nil || !ct.IsMultirange || ct.RangeType == "" {
+		return ""
+	}
+	return "::" + ct.RangeType
+}
+
+// hasNullFilter reports whether a null filter of the condition tree tests the embedded resource
+func hasNullFilter(node *WhereConditionNode, embed *SelectField) bool {
+	if node == nil {
+		return false
+	}
+	if node.embed == embed {
+		return true
+	}
+	for _, n := range node.children {
+		if hasNullFilter(n, embed) {
+			return true
+		}
+	}
+	return false
+}
+
+// embedNullCondition tests the existence of the rows of an embedded resource, referencing
+// the lateral join built by selectClause for the same resource.
+func embedNullCondition(table, schema string, node *WhereConditionNode, stack BuildStack) string {
+	rel := node.embed.relation
+	parentTable := rel.parent
+	if parentTable == "" {
+		parentTable = table
+	}
+	labelRelName := node.embed.label
+	if labelRelName == "" {
+		labelRelName = rel.name
+	}
+	joinName := labelWithNumber(parentTable+"_"+labelRelName, stack.level+1)
+	isNull := node.values[0] == "null"
+	// the relationship has already been resolved by selectClause
+	frel, _ := findRelationship(parentTable, rel.name, rel.fk, schema, stack.info)
+	if frel != nil && (frel.Type == O2M || frel.Type == M2M || frel.Type == Computed && frel.ReturnIsSet) {
+		// json_agg returns NULL when there are no rows
+		if isNull {
+			return quote(joinName) + ".\"_" + joinName + "\" IS NULL"
+		}
+		return quote(joinName) + ".\"_" + joinName + "\" IS NOT NULL"
+	}
+	// a to-one row whose columns are all null is still distinct from NULL
+	if isNull {
+		return quote(joinName) + " IS NOT DISTINCT FROM NULL"
+	}
+	return quote(joinName) + " IS DISTINCT FROM NULL"
+}
+
 // returningClause
 func returningClause(table, schema string, parts *QueryParts, info *SchemaInfo) (ret, sel string) {
 	ret += " RETURNING "
@@ -752,7 +1245,7 @@ func returningClause(table, schema string, parts *QueryParts, info *SchemaInfo)
 		}
 		ret += fields
 		if hasResourceEmbed {
-			sc, joins, keys, _ := selectClause(table, schema, "", parts, BuildStack{info: info, afterWithClause: true})
+			sc, joins, _, keys, _ := selectClause(table, schema, "", parts, BuildStack{info: info, afterWithClause: true})
 			// add foreign keys to Returning clause if they are not already present
 			for _, k := range keys {
 				if _, exists := fieldMap[k]; !exists {
@@ -810,6 +1303,19 @@ func onConflictClause(table, schema string, fields []string,
 	return s
 }
 
+// variadicArgument returns the name of the VARIADIC argument of a function, or "" if it has none
+func variadicArgument(f *Function) string {
+	if f == nil || !f.IsVariadic {
+		return ""
+	}
+	for _, arg := range f.Arguments {
+		if arg.Mode == 'v' {
+			return arg.Name
+		}
+	}
+	return ""
+}
+
 // orderedRecordKeys returns record's keys in a deterministic order for use
 // when building RPC CALL sites. When a function signature is available and
 // has no unnamed parameters, IN/INOUT/VARIADIC argument names are emitted in
@@ -870,38 +1376,54 @@ func (CommonBuilder) BuildInsert(table string, records []Record, parts *QueryPar
 	// if len(records) == 0 {
 	// 	return "", nil, fmt.Errorf("no records to insert")
 	// }
-	var n int
-	for key := range records[0] {
-		// check if there are specified columns
-		if len(parts.columnFields) > 0 {
-			if _, ok := parts.columnFields[key]; !ok {
+	// the columns are the keys of the first record or, with missing=default,
+	// of all the records
+	keyRecords := records[:1]
+	if options.MissingDefault {
+		keyRecords = records
+	}
+	seen := map[string]struct{}{}
+	for _, record := range keyRecords {
+		for key := range record {
+			// check if there are specified columns
+			if len(parts.columnFields) > 0 {
+				if _, ok := parts.columnFields[key]; !ok {
+					continue
+				}
+			}
+			if _, ok := seen[key]; ok {
 				continue
 			}
+			seen[key] = struct{}{}
+			if fields != "" {
+				fields += ", "
+			}
+			fields += quote(key)
+			fieldList = append(fieldList, key)
 		}
-		n += 1
-		if fields != "" {
-			fields += ", "
-		}
-		fields += quote(key)
-		fieldList = append(fieldList, key)
 	}
-	var j int
+	var nmarker int
 	for i, record := range records {
 		if i > 0 {
 			values += "), ("
 		}
-		for _, f := range fieldList {
+		for j, f := range fieldList {
 			if j > 0 {
 				values += ", "
 			}
-			j += 1
-			values += "$" + strconv.Itoa(i*n+j)
-			valueList = append(valueList, record[f])
+			value, ok := record[f]
+			if !ok && options.MissingDefault {
+				// the column default, not NULL
+				values += "DEFAULT"
+				continue
+			}
+			nmarker += 1
+			values += "$" + strconv.Itoa(nmarker)
+			valueList = append(valueList, value)
 		}
-		j = 0
 	}
 	schema := options.Schema
-	if n > 0 {
+	if len(fieldList) > 0 {
 		insert = "INSERT INTO " + _sq(table, schema) + " (" + fields + ") VALUES (" + values + ")"
 	} else {
 		insert = "INSERT INTO " + _sq(table, schema) + " DEFAULT VALUES"
@@ -917,6 +1439,18 @@ func (CommonBuilder) BuildInsert(table string, records []Record, parts *QueryPar
 		if sel != "" {
 			insert = "WITH _source AS (" + insert + ") " + sel
 		}
+	} else if len(records) == 1 && info != nil {
+		// the primary key of the created row, for the Location header
+		if pk := info.GetPrimaryKey(_s(table, schema)); pk != nil {
+			insert += " RETURNING "
+			for i, col := range pk.Columns {
+				if i != 0 {
+					insert += ", "
+				}
+				insert += quote(col) + "::text"
+			}
+			options.locationKeys = pk.Columns
+		}
 	}
 	return insert, valueList, nil
 }
@@ -945,6 +1479,11 @@ func (CommonBuilder) BuildUpdate(table string, record Record, parts *QueryParts,
 	schema := options.Schema
 	whereClause, whereValueList := whereClause(table, schema, "", parts.whereConditionsTree, i, stack)
 	valueList = append(valueList, whereValueList...)
+	if whereClause == "" {
+		if err := checkUnfilteredWrite("UPDATE", options); err != nil {
+			return "", nil, err
+		}
+	}
 	update = "UPDATE " + _sq(table, schema) + " SET " + pairs
 	if whereClause != "" {
 		update += " WHERE " + whereClause
@@ -965,6 +1504,11 @@ func (CommonBuilder) BuildDelete(table string, parts *QueryParts, options *Query
 	stack := BuildStack{info: info}
 	schema := options.Schema
 	whereClause, valueList := whereClause(table, schema, "", parts.whereConditionsTree, 0, stack)
+	if whereClause == "" {
+		if err := checkUnfilteredWrite("DELETE", options); err != nil {
+			return "", nil, err
+		}
+	}
 	delete = "DELETE FROM " + _sq(table, schema)
 	if whereClause != "" {
 		delete += " WHERE " + whereClause
@@ -979,8 +1523,254 @@ func (CommonBuilder) BuildDelete(table string, parts *QueryParts, options *Query
 	return delete, valueList, nil
 }
 
-func buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClause string, valueList []any, parts *QueryParts, options *QueryOptions) (string, []any, error) {
+// BuildUpsert builds the single-row upsert of PUT. The filters select the row with eq
+// conditions on all the columns of the primary key, with the same values as the
+// record, which must contain all the columns of the table.
+func (CommonBuilder) BuildUpsert(table string, record Record, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (
+	upsert string, valueList []any, err error) {
+
+	schema := options.Schema
+	if options.HasRange || parts.limit != "" || parts.offset != "" {
+		return "", nil, &BuildError{"Range header and limit/offset querystring parameters are not allowed for PUT"}
+	}
+	var pk *Constraint
+	if info != nil {
+		pk = info.GetPrimaryKey(_s(table, schema))
+	}
+	keys := primaryKeyFilters(table, parts.whereConditionsTree)
+	if pk == nil || keys == nil || len(keys) != len(pk.Columns) {
+		return "", nil, &MethodError{"Filters must include all and only primary key columns with 'eq' operators"}
+	}
+	for _, col := range pk.Columns {
+		value, ok := keys[col]
+		if !ok {
+			return "", nil, &MethodError{"Filters must include all and only primary key columns with 'eq' operators"}
+		}
+		if recordValueText(record[col]) != value {
+			return "", nil, &BuildError{"Payload values do not match URL in primary key column(s)"}
+		}
+	}
+	for col := range info.cachedColumnTypes[_s(table, schema)] {
+		if _, ok := record[col]; !ok {
+			return "", nil, &BuildError{"You must specify all columns in the payload when using PUT"}
+		}
+	}
+
+	var fields, values string
+	fieldList := orderedRecordKeys(record, nil, nil)
+	for i, key := range fieldList {
+		if i != 0 {
+			fields += ", "
+			values += ", "
+		}
+		fields += quote(key)
+		values += "$" + strconv.Itoa(i+1)
+		valueList = append(valueList, record[key])
+	}
+	upsert = "INSERT INTO " + _sq(table, schema) + " (" + fields + ") VALUES (" + values + ")"
+	upsert += onConflictClause(table, schema, fieldList, pk.Columns, &QueryOptions{MergeDuplicates: true}, info)
+	if options.ReturnRepresentation {
+		ret, sel := returningClause(table, schema, parts, info)
+		upsert += ret
+		if sel != "" {
+			upsert = "WITH _source AS (" + upsert + ") " + sel
+		}
+	}
+	return upsert, valueList, nil
+}
+
+// primaryKeyFilters returns the values of the filters of a PUT, or nil if they
+// are not only column = value conditions on the table, at most one per column
+func primaryKeyFilters(table string, node *WhereConditionNode) map[string]string {
+	if node == nil {
+		return nil
+	}
+	keys := map[string]string{}
+	for _, n := range node.children {
+		if n.operator != "=" || n.not || n.opModifier != "" || n.embed != nil || len(n.children) != 0 ||
+			n.field.jsonPath != "" || n.field.tablename != table || len(n.values) != 1 {
+			return nil
+		}
+		if _, ok := keys[n.field.name]; ok {
+			return nil
+		}
+		keys[n.field.name] = n.values[0]
+	}
+	return keys
+}
+
+// recordValueText returns a value of a JSON record as text, to compare it with a filter
+func recordValueText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// keysetPagination prepares the keyset pagination requested with after: it completes the order
+// with the primary key, so that each row has a distinct position, and adds to the where clause
+// the condition selecting the rows past the cursor, if any.
+// It returns the keys of the order, whose values in the last row of a page form the next cursor.
+func keysetPagination(table, schema string, parts *QueryParts, where string, valueList []any, info *SchemaInfo) (string, string, []any, error) {
+	if !parts.keyset {
+		return "", where, valueList, nil
+	}
+	if parts.recursive != nil {
+		return "", "", nil, &BuildError{"keyset pagination is not available with recursive queries"}
+	}
+	for _, sfield := range parts.selectFields {
+		if sfield.aggregate != "" {
+			return "", "", nil, &BuildError{"keyset pagination is not available with aggregates"}
+		}
+	}
+	if parts.distinct {
+		return "", "", nil, &BuildError{"keyset pagination is not available with distinct"}
+	}
+	var pk *Constraint
+	if info != nil {
+		pk = info.GetPrimaryKey(_s(table, schema))
+	}
+	if pk == nil {
+		return "", "", nil, &BuildError{"keyset pagination requires a primary key on '" + table + "'"}
+	}
+	descending := false
+	ordered := map[string]struct{}{}
+	for _, o := range parts.orderFields {
+		if o.field.tablename != table {
+			continue
+		}
+		if o.relation != "" || o.field.jsonPath != "" || o.distance != "" || o.textSearch != nil || o.similar != "" {
+			return "", "", nil, &BuildError{"keyset pagination can only order by the columns of '" + table + "'"}
+		}
+		descending = o.descending
+		ordered[o.field.name] = struct{}{}
+	}
+	for _, col := range pk.Columns {
+		if _, ok := ordered[col]; !ok {
+			parts.orderFields = append(parts.orderFields,
+				OrderField{field: Field{name: col, tablename: table}, descending: descending})
+		}
+	}
+	var keys []string
+	var orderFields []OrderField
+	for _, o := range parts.orderFields {
+		if o.field.tablename != table {
+			continue
+		}
+		key := _stq(o.field.name, schema, table)
+		if ref := computedField(table, schema, o.field.name, quote(table), info); ref != "" {
+			key = ref
+		}
+		keys = append(keys, key)
+		orderFields = append(orderFields, o)
+	}
+	if parts.after != "" {
+		values, err := decodeCursor(parts.after, len(keys))
+		if err != nil {
+			return "", "", nil, err
+		}
+		if where != "" {
+			where += " AND "
+		}
+		where += keysetCondition(keys, orderFields, len(valueList))
+		valueList = append(valueList, values...)
+	}
+	return strings.Join(keys, ", "), where, valueList, nil
+}
+
+// keysetCondition returns the condition for the rows following the cursor in the order,
+// whose values are in the markers starting from nmarker+1.
+// A row comparison is used when all the keys have the same direction, eg. (a, b) > ($1, $2),
+// otherwise it is expanded, eg. (a > $1 OR a = $1 AND b < $2).
+func keysetCondition(keys []string, orderFields []OrderField, nmarker int) string {
+	sameDirection := true
+	for _, o := range orderFields {
+		if o.descending != orderFields[0].descending {
+			sameDirection = false
+		}
+	}
+	op := func(o OrderField) string {
+		if o.descending {
+			return " < "
+		}
+		return " > "
+	}
+	markers := make([]string, len(keys))
+	for i := range keys {
+		markers[i] = "$" + strconv.Itoa(nmarker+i+1)
+	}
+	if sameDirection {
+		if len(keys) == 1 {
+			return keys[0] + op(orderFields[0]) + markers[0]
+		}
+		return "(" + strings.Join(keys, ", ") + ")" + op(orderFields[0]) + "(" + strings.Join(markers, ", ") + ")"
+	}
+	var cond string
+	for i := len(keys) - 1; i >= 0; i-- {
+		c := keys[i] + op(orderFields[i]) + markers[i]
+		if cond != "" {
+			c = "(" + c + " OR " + keys[i] + " = " + markers[i] + " AND " + cond + ")"
+		}
+		cond = c
+	}
+	return cond
+}
+
+// encodeCursor makes an opaque cursor from the JSON array of the keys of a row
+func encodeCursor(keys string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(keys))
+}
+
+// decodeCursor returns the values of the n keys in a cursor made by encodeCursor
+func decodeCursor(cursor string, n int) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, &BuildError{"invalid cursor"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var keys []any
+	if err := decoder.Decode(&keys); err != nil || len(keys) != n {
+		return nil, &BuildError{"invalid cursor"}
+	}
+	values := make([]any, n)
+	for i, key := range keys {
+		switch v := key.(type) {
+		case string:
+			values[i] = v
+		case json.Number:
+			values[i] = v.String()
+		case bool:
+			values[i] = strconv.FormatBool(v)
+		default:
+			// null keys cannot be compared
+			return nil, &BuildError{"invalid cursor"}
+		}
+	}
+	return values, nil
+}
+
+func buildAfterSelect(selectList, distinctClause, from, joins, countJoins, whereClause, groupByClause, havingClause, orderClause, keys string, valueList []any, parts *QueryParts, options *QueryOptions) (string, []any, error) {
 	nmarker := len(valueList)
+	hasLimit := parts.limit != "" || options.HasRange && options.RangeMax != -1
+	if keys != "" && options.ContentType == "application/geo+json" {
+		return "", nil, &BuildError{"keyset pagination is not available with application/geo+json"}
+	}
+	if keys != "" && hasLimit {
+		// the cursor of each row, the last one of a full page is the next cursor
+		selectList += ", json_build_array(" + keys + ")::text AS __cursor"
+	}
+	query := "SELECT " + distinctClause + selectList
 	query += " " + from
 	if joins != "" {
 		query += " " + joins
@@ -991,11 +1781,16 @@ func buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClaus
 	if groupByClause != "" {
 		query += " GROUP BY " + groupByClause
 	}
+	if havingClause != "" {
+		query += " HAVING " + havingClause
+	}
+	// the distinct or grouped rows, before ordering and paging, for the count
+	countedRows := query
 	if orderClause != "" {
 		query += " ORDER BY " + orderClause
 	}
 	var limit int64 = -1
-	if parts.limit != "" || options.HasRange && options.RangeMax != -1 {
+	if hasLimit {
 		nmarker += 1
 		query += " LIMIT $" + strconv.Itoa(nmarker)
 		if options.HasRange {
@@ -1022,10 +1817,56 @@ func buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClaus
 		}
 		valueList = append(valueList, offset)
 	}
-	if options.Count != "" && (limit != -1 || offset > 0) {
-		countQuery := "WITH Total AS (SELECT COUNT(*) AS __count " + from
-		if whereClause != "" {
-			countQuery += " WHERE " + whereClause
+	if keys != "" && hasLimit {
+		options.cursorLimit = limit
+	}
+	// the planner estimates the rows of the data query, not those of its wrappers
+	// (a json_agg, for one, is planned as a single row)
+	dataQuery := query
+	if options.Count == "planned" {
+		options.estimateQuery = dataQuery
+	}
+	if options.ContentType == "application/geo+json" {
+		// a feature for each row, with the first geometry or geography column
+		// as the geometry and the others as the properties
+		if options.geoColumn != "" {
+			query = "SELECT json_build_object('type', 'Feature', 'geometry', ST_AsGeoJSON(_geo." + quote(options.geoColumn) +
+				"::geometry)::json, 'properties', to_jsonb(_geo.*) - " + quoteLit(options.geoColumn) + ")::text AS __feature FROM (" + query + ") AS _geo"
+		} else {
+			// PostGIS takes the first geometry column
+			query = "SELECT ST_AsGeoJSON(_geo.*)::text AS __feature FROM (" + query + ") AS _geo"
+		}
+	}
+	if options.jsonRows {
+		// the rows of the page are aggregated before the count is added
+		query = "SELECT json_agg(_rows) AS __json FROM (" + query + ") AS _rows"
+	}
+	// count=planned never embeds a count: the executor reads it from the plan.
+	if (options.Count == "exact" || options.Count == "estimated") && (limit != -1 || offset > 0) {
+		var countQuery string
+		// only inner joins and embedded resources with null filters (rel=is.null) restrict the rows
+		if countJoins != "" {
+			from += " " + countJoins
+		}
+		if distinctClause != "" || groupByClause != "" || havingClause != "" {
+			// only the distinct rows or the groups are counted
+			from = "FROM (" + countedRows + ") AS __rows"
+			whereClause = ""
+		}
+		if options.Count == "exact" {
+			countQuery = "WITH Total AS (SELECT COUNT(*) AS __count " + from
+			if whereClause != "" {
+				countQuery += " WHERE " + whereClause
+			}
+		} else {
+			// count=estimated: the exact count stops one row past the threshold,
+			// beyond which the executor switches to the planner estimate.
+			countQuery = "WITH Total AS (SELECT COUNT(*) AS __count FROM (SELECT 1 " + from
+			if whereClause != "" {
+				countQuery += " WHERE " + whereClause
+			}
+			countQuery += " LIMIT " + strconv.Itoa(estimatedCountThreshold()+1) + ") AS __capped"
+			options.estimateQuery = dataQuery
 		}
 		query = countQuery +
 			"), Data AS (" + query +
@@ -1043,12 +1884,34 @@ func buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClaus
 	return query, valueList, nil
 }
 
+const defaultEstimatedCountThreshold = 1000
+
+// estimatedCountThreshold returns the number of rows counted exactly with count=estimated
+func estimatedCountThreshold() int {
+	if dbe != nil && dbe.config.EstimatedCountThreshold > 0 {
+		return dbe.config.EstimatedCountThreshold
+	}
+	return defaultEstimatedCountThreshold
+}
+
+// checkUnfilteredWrite rejects an UPDATE or DELETE without filters when they are
+// disabled in the configuration and the request hasn't opted in with unfiltered=allow
+func checkUnfilteredWrite(command string, options *QueryOptions) error {
+	if dbe != nil && !dbe.config.UnfilteredWritesEnabled && !options.AllowUnfiltered {
+		return &BuildError{command + " without filters is not allowed: add a filter or Prefer: unfiltered=allow"}
+	}
+	return nil
+}
+
 const defaultMaxRecursiveDepth = 100
 
 func buildRecursiveSelect(table, schema string, parts *QueryParts, options *QueryOptions,
 	selectClause, mainWhere, orderClause, joins string,
 	valueList []any, info *SchemaInfo) (string, []any, error) {
 
+	if options.ContentType == "application/geo+json" {
+		return "", nil, &BuildError{"GeoJSON is not available with recursive queries"}
+	}
 	rec := parts.recursive
 	qtable := _sq(table, schema)
 	startField := quote(rec.StartField)
@@ -1388,6 +2251,7 @@ func (CommonBuilder) BuildExecute(name string, record Record, parts *QueryParts,
 	// the declared function-signature order; fall back to alphabetical.
 	keys := orderedRecordKeys(record, parts.columnFields, f)
 
+	variadic := variadicArgument(f)
 	var pairs string
 	var i int
 	for _, key := range keys {
@@ -1395,12 +2259,22 @@ func (CommonBuilder) BuildExecute(name string, record Record, parts *QueryParts,
 			pairs += ", "
 		}
 		i++
+		value := record[key]
+		if key != "" && key == variadic {
+			// the variadic argument receives all its values as an array
+			pairs += "VARIADIC "
+			switch value.(type) {
+			case []any, []string:
+			default:
+				value = []any{value}
+			}
+		}
 		if key != "" { // unnamed parameter @@ to be continued
 			pairs += quote(key)
 			pairs += " := "
 		}
 		pairs += "$" + strconv.Itoa(i)
-		valueList = append(valueList, record[key])
+		valueList = append(valueList, value)
 	}
 
 	// Extract the return type and discover if it is a table.
@@ -1417,7 +2291,7 @@ func (CommonBuilder) BuildExecute(name string, record Record, parts *QueryParts,
 			s = rettype.Schema
 		}
 	}
-	selectClause, joins, _, err := selectClause(t, s, "t", parts, stack)
+	selectClause, joins, countJoins, _, err := selectClause(t, s, "t", parts, stack)
 	if err != nil {
 		return "", nil, err
 	}
@@ -1444,18 +2318,24 @@ func (CommonBuilder) BuildExecute(name string, record Record, parts *QueryParts,
 	}
 	whereClause, whereValueList := whereClause("t", "", name, parts.whereConditionsTree, i, stack)
 	valueList = append(valueList, whereValueList...)
-	// patch order fields
+	// patch order and distinct fields
 	for i := range parts.orderFields {
 		parts.orderFields[i].field.tablename = "t"
 	}
+	for i := range parts.distinctFields {
+		parts.distinctFields[i].field.tablename = "t"
+	}
 	orderClause, err := orderClause("t", "", "", 0, parts.orderFields, parts.selectFields, info)
 	if err != nil {
 		return "", nil, err
 	}
-	query = "SELECT " + selectClause
+	distinctClause, err := distinctClause("t", "", parts, info)
+	if err != nil {
+		return "", nil, err
+	}
 	from := "FROM " + _sq(name, schema) + "(" + pairs + ") t "
 
-	return buildAfterSelect(query, from, joins, whereClause, "", orderClause, valueList, parts, options)
+	return buildAfterSelect(selectClause, distinctClause, from, joins, countJoins, whereClause, "", "", orderClause, "", valueList, parts, options)
 }
 
 type DirectQueryBuilder struct {
@@ -1465,29 +2345,87 @@ type DirectQueryBuilder struct {
 func (DirectQueryBuilder) BuildSelect(table string, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error) {
 	stack := BuildStack{info: info}
 	schema := options.Schema
-	selectClause, joins, _, err := selectClause(table, schema, "", parts, stack)
+	selectClause, joins, countJoins, _, err := selectClause(table, schema, "", parts, stack)
 	if err != nil {
 		return "", nil, err
 	}
 	whereClause, valueList := whereClause(table, schema, "", parts.whereConditionsTree, 0, stack)
-	groupByClause := groupByClause(table, schema, parts, info)
+	keys, whereClause, valueList, err := keysetPagination(table, schema, parts, whereClause, valueList, info)
+	if err != nil {
+		return "", nil, err
+	}
+	groupByClause := groupByClause(table, schema, "", parts.selectFields, info)
+	havingClause, valueList := havingClause(table, schema, parts, valueList, stack)
 	orderClause, err := orderClause(table, schema, "", 0, parts.orderFields, parts.selectFields, info)
 	if err != nil {
 		return "", nil, err
 	}
+	distinctClause, err := distinctClause(table, schema, parts, info)
+	if err != nil {
+		return "", nil, err
+	}
 
 	if parts.recursive != nil {
-		if groupByClause != "" {
+		if groupByClause != "" || havingClause != "" {
 			return "", nil, &ParseError{"aggregate functions cannot be used with recursive queries"}
 		}
+		if distinctClause != "" {
+			return "", nil, &ParseError{"distinct cannot be used with recursive queries"}
+		}
 		return buildRecursiveSelect(table, schema, parts, options,
 			selectClause, whereClause, orderClause, joins, valueList, info)
 	}
 
-	query := "SELECT " + selectClause
 	from := "FROM " + _sq(table, schema)
+	if options.ContentType == "application/geo+json" {
+		options.geoColumn = geoColumn(table, schema, parts.selectFields, info)
+	}
 
-	return buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClause, valueList, parts, options)
+	return buildAfterSelect(selectClause, distinctClause, from, joins, countJoins, whereClause, groupByClause, havingClause, orderClause, keys, valueList, parts, options)
+}
+
+// geoColumn returns the label of the first geometry or geography column of the select list,
+// or of the table for *
+func geoColumn(table, schema string, selectFields []SelectField, info *SchemaInfo) string {
+	if info == nil {
+		return ""
+	}
+	ftable := _s(table, schema)
+	isGeo := func(ct *ColumnType) bool {
+		return ct != nil && (ct.Type == "geometry" || ct.Type == "geography")
+	}
+	if len(selectFields) == 0 {
+		selectFields = []SelectField{{field: Field{name: "*"}}}
+	}
+	for _, sfield := range selectFields {
+		if sfield.relation != nil || sfield.field.jsonPath != "" || sfield.aggregate != "" ||
+			sfield.distance != "" || sfield.textSearch != nil || sfield.similar != "" {
+			continue
+		}
+		if sfield.field.name == "*" {
+			var first *ColumnType
+			for _, ct := range info.cachedColumnTypes[ftable] {
+				if isGeo(&ct) && (first == nil || ct.Position < first.Position) {
+					first = &ct
+				}
+			}
+			if first != nil {
+				return first.Name
+			}
+			continue
+		}
+		geo := isGeo(info.GetColumnType(ftable, sfield.field.name))
+		if sfield.cast != "" {
+			geo = sfield.cast == "geometry" || sfield.cast == "geography"
+		}
+		if geo {
+			if sfield.label != "" {
+				return sfield.label
+			}
+			return sfield.field.name
+		}
+	}
+	return ""
 }
 
 func (DirectQueryBuilder) preferredSerializer() TextSerializer {
@@ -1499,35 +2437,52 @@ type QueryWithJSON struct {
 }
 
 func (QueryWithJSON) BuildSelect(table string, parts *QueryParts, options *QueryOptions, info *SchemaInfo) (string, []any, error) {
+	if parts.keyset {
+		// the rows are aggregated by the database, without their cursors
+		return "", nil, &BuildError{"keyset pagination is not available with this query builder"}
+	}
+	if options.ContentType == "application/geo+json" {
+		// the features are built row by row, not aggregated by the database
+		return "", nil, &BuildError{"GeoJSON is not available with this query builder"}
+	}
 	stack := BuildStack{info: info}
 	schema := options.Schema
-	selectClause, joins, _, err := selectClause(table, schema, "", parts, stack)
+	selectClause, joins, countJoins, _, err := selectClause(table, schema, "", parts, stack)
 	if err != nil {
 		return "", nil, err
 	}
 	whereClause, valueList := whereClause(table, schema, "", parts.whereConditionsTree, 0, stack)
-	groupByClause := groupByClause(table, schema, parts, info)
+	keys, whereClause, valueList, err := keysetPagination(table, schema, parts, whereClause, valueList, info)
+	if err != nil {
+		return "", nil, err
+	}
+	groupByClause := groupByClause(table, schema, "", parts.selectFields, info)
+	havingClause, valueList := havingClause(table, schema, parts, valueList, stack)
 	orderClause, err := orderClause(table, schema, "", 0, parts.orderFields, parts.selectFields, info)
 	if err != nil {
 		return "", nil, err
 	}
+	distinctClause, err := distinctClause(table, schema, parts, info)
+	if err != nil {
+		return "", nil, err
+	}
 
 	if parts.recursive != nil {
-		if groupByClause != "" {
+		if groupByClause != "" || havingClause != "" {
 			return "", nil, &ParseError{"aggregate functions cannot be used with recursive queries"}
 		}
+		if distinctClause != "" {
+			return "", nil, &ParseError{"distinct cannot be used with recursive queries"}
+		}
 		return buildRecursiveSelect(table, schema, parts, options,
 			selectClause, whereClause, orderClause, joins, valueList, info)
 	}
 
-	query := "SELECT "
-	if selectClause == "*" {
-		query += "json_agg(" + table + ")"
-	} else {
-		query += "SELECT " + selectClause
-	}
-	from := "FROM " + table
-	return buildAfterSelect(query, from, joins, whereClause, groupByClause, orderClause, valueList, parts, options)
+	// the rows, with their embedded resources, are selected first and then aggregated
+	from := "FROM " + _sq(table, schema)
+	options.jsonRows = true
+
+	return buildAfterSelect(selectClause, distinctClause, from, joins, countJoins, whereClause, groupByClause, havingClause, orderClause, keys, valueList, parts, options)
 }
 
 func (QueryWithJSON) preferredSerializer() TextSerializer {
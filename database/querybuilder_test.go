@@ -1,7 +1,10 @@
 package database
 
 import (
+	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -594,3 +597,1433 @@ func TestBuildExecuteDeterministicOrder(t *testing.T) {
 		}
 	})
 }
+
+// TestCountStrategies checks how each Prefer: count=... strategy shapes a paginated select:
+// exact and estimated embed the count (estimated stops it past the threshold), planned
+// leaves it to the planner.
+func TestCountStrategies(t *testing.T) {
+	tests := []struct {
+		count       string
+		countPrefix string
+	}{
+		{"exact", `WITH Total AS (SELECT COUNT(*) AS __count FROM "table" WHERE "table"."a" = $1), Data AS (`},
+		{"estimated", `WITH Total AS (SELECT COUNT(*) AS __count FROM (SELECT 1 FROM "table" WHERE "table"."a" = $1 LIMIT 1001) AS __capped), Data AS (`},
+		{"planned", ``},
+	}
+	const dataQuery = `SELECT * FROM "table" WHERE "table"."a" = $1 LIMIT $2`
+
+	for _, test := range tests {
+		u, _ := url.Parse("?a=eq.1&limit=10")
+		parts, err := PostgRestParser{}.parse("table", u.Query())
+		if err != nil {
+			t.Fatal(err)
+		}
+		options := &QueryOptions{Count: test.count}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("table", parts, options, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareValues(values, []any{"1", int64(10)}) {
+			t.Errorf("%s: unexpected values %v", test.count, values)
+		}
+		if test.countPrefix == "" {
+			if query != dataQuery {
+				t.Errorf("%s: expected\n\t%s\ngot\n\t%s", test.count, dataQuery, query)
+			}
+			continue
+		}
+		if !strings.HasPrefix(query, test.countPrefix+dataQuery+")") {
+			t.Errorf("%s: expected prefix\n\t%s\ngot\n\t%s", test.count, test.countPrefix+dataQuery, query)
+		}
+		if test.count != "exact" && options.estimateQuery != dataQuery {
+			t.Errorf("%s: expected estimate query\n\t%s\ngot\n\t%s", test.count, dataQuery, options.estimateQuery)
+		}
+	}
+
+	// QueryWithJSON aggregates the page inside the count wrapper
+	u, _ := url.Parse("?a=eq.1&limit=10")
+	parts, _ := PostgRestParser{}.parse("table", u.Query())
+	query, _, err := QueryWithJSON{}.BuildSelect("table", parts, &QueryOptions{Count: "exact"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `WITH Total AS (SELECT COUNT(*) AS __count FROM "table" WHERE "table"."a" = $1), Data AS (SELECT json_agg(_rows) AS __json FROM (` + dataQuery + `) AS _rows)`
+	if !strings.HasPrefix(query, expected) {
+		t.Errorf("QueryWithJSON: expected prefix\n\t%s\ngot\n\t%s", expected, query)
+	}
+	// the planner estimates the rows, not the single row of their json_agg
+	for _, count := range []string{"planned", "estimated"} {
+		parts, _ := PostgRestParser{}.parse("table", u.Query())
+		options := &QueryOptions{Count: count}
+		if _, _, err := (QueryWithJSON{}).BuildSelect("table", parts, options, nil); err != nil {
+			t.Fatal(err)
+		}
+		if options.estimateQuery != dataQuery {
+			t.Errorf("QueryWithJSON %s: expected estimate query\n\t%s\ngot\n\t%s", count, dataQuery, options.estimateQuery)
+		}
+	}
+}
+
+// TestCountJoins checks that the count joins only the embedded resources that restrict the rows
+func TestCountJoins(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.clients": {
+				{Type: O2M, Table: "public.clients", Columns: []string{"id"}, RelatedTable: "public.projects", RelatedColumns: []string{"client_id"}},
+			},
+		},
+	}
+	tests := []struct {
+		query  string
+		joined bool
+	}{
+		{"?select=name,projects(name)&limit=10", false},
+		{"?select=name,projects!inner(name)&limit=10", true},
+		{"?select=name,projects(name)&projects=is.null&limit=10", true},
+		{"?select=name,projects(name)&or=(name.eq.a,projects.not.is.null)&limit=10", true},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("clients", u.Query())
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect("clients", parts, &QueryOptions{Schema: "public", Count: "exact"}, info)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		total, _, _ := strings.Cut(query, "), Data AS (")
+		if strings.Contains(total, "JOIN LATERAL") != test.joined {
+			t.Errorf("%s: unexpected count query\n\t%s", test.query, total)
+		}
+	}
+}
+
+func TestEmbeddedPaging(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.clients": {
+				{Type: O2M, Table: "public.clients", Columns: []string{"id"}, RelatedTable: "public.projects", RelatedColumns: []string{"client_id"}},
+			},
+			"public.projects": {
+				{Type: M2O, Table: "public.projects", Columns: []string{"client_id"}, RelatedTable: "public.clients", RelatedColumns: []string{"id"}},
+				{Type: O2M, Table: "public.projects", Columns: []string{"id"}, RelatedTable: "public.tasks", RelatedColumns: []string{"project_id"}},
+			},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+	}{
+		{
+			"clients",
+			"?select=id,projects(id)&projects.limit=2&projects.offset=1&projects.order=id.desc",
+			`SELECT "public"."clients"."id",  COALESCE("clients_projects_1"."_clients_projects_1", '[]') AS "projects" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_projects_1") AS "_clients_projects_1" FROM ( SELECT "projects_1"."id" FROM "public"."projects" AS "projects_1" WHERE "projects_1"."client_id" = "public"."clients"."id" ORDER BY "projects_1"."id" DESC LIMIT 2 OFFSET 1 ) AS "_clients_projects_1") AS "clients_projects_1" ON TRUE`,
+		},
+		{
+			// nested, addressed by label
+			"clients",
+			"?select=id,p:projects(id,tasks(id))&p.tasks.limit=1&p.tasks.order=id",
+			`SELECT "public"."clients"."id",  COALESCE("clients_p_1"."_clients_p_1", '[]') AS "p" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_p_1") AS "_clients_p_1" FROM ( SELECT "projects_1"."id",  COALESCE("projects_tasks_2"."_projects_tasks_2", '[]') AS "tasks" FROM "public"."projects" AS "projects_1"  LEFT JOIN LATERAL ( SELECT json_agg("_projects_tasks_2") AS "_projects_tasks_2" FROM ( SELECT "tasks_2"."id" FROM "public"."tasks" AS "tasks_2" WHERE "tasks_2"."project_id" = "projects_1"."id" ORDER BY "tasks_2"."id" LIMIT 1 ) AS "_projects_tasks_2") AS "projects_tasks_2" ON TRUE WHERE "projects_1"."client_id" = "public"."clients"."id" ) AS "_clients_p_1") AS "clients_p_1" ON TRUE`,
+		},
+		{
+			// ordered by label
+			"clients",
+			"?select=id,p:projects(id)&p.order=id.desc&p.limit=2",
+			`SELECT "public"."clients"."id",  COALESCE("clients_p_1"."_clients_p_1", '[]') AS "p" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_p_1") AS "_clients_p_1" FROM ( SELECT "projects_1"."id" FROM "public"."projects" AS "projects_1" WHERE "projects_1"."client_id" = "public"."clients"."id" ORDER BY "projects_1"."id" DESC LIMIT 2 ) AS "_clients_p_1") AS "clients_p_1" ON TRUE`,
+		},
+		{
+			// a to-one embed has a single row: limit is ignored
+			"projects",
+			"?select=id,clients(id)&clients.limit=1",
+			`SELECT "public"."projects"."id",  row_to_json("projects_clients_1".*) AS "clients" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT "clients_1"."id" FROM "public"."clients" AS "clients_1" WHERE "clients_1"."id" = "public"."projects"."client_id") AS "projects_clients_1" ON TRUE`,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+	}
+
+	for _, query := range []string{
+		"?select=id,projects(id)&projects.limit=-1",
+		"?select=id,projects(id)&projects.offset=x",
+		"?select=id&projects.limit=2",
+		"?select=id,p:projects(id)&q.order=id",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("clients", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
+
+func TestEmbedNullFilters(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.clients": {
+				{Type: O2M, Table: "public.clients", Columns: []string{"id"}, RelatedTable: "public.projects", RelatedColumns: []string{"client_id"}},
+			},
+			"public.projects": {
+				{Type: M2O, Table: "public.projects", Columns: []string{"client_id"}, RelatedTable: "public.clients", RelatedColumns: []string{"id"}},
+			},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+	}{
+		{
+			// anti-join on a to-one embed
+			"projects",
+			"?select=id,clients(id)&clients=is.null",
+			`SELECT "public"."projects"."id",  row_to_json("projects_clients_1".*) AS "clients" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT "clients_1"."id" FROM "public"."clients" AS "clients_1" WHERE "clients_1"."id" = "public"."projects"."client_id") AS "projects_clients_1" ON TRUE WHERE "projects_clients_1" IS NOT DISTINCT FROM NULL`,
+		},
+		{
+			// semi-join on a to-many embed, with an embedded filter
+			"clients",
+			"?select=id,projects(id)&projects.id=gt.1&projects=not.is.null",
+			`SELECT "public"."clients"."id",  COALESCE("clients_projects_1"."_clients_projects_1", '[]') AS "projects" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_projects_1") AS "_clients_projects_1" FROM ( SELECT "projects_1"."id" FROM "public"."projects" AS "projects_1" WHERE "projects_1"."client_id" = "public"."clients"."id" AND "projects_1"."id" > '1' ) AS "_clients_projects_1") AS "clients_projects_1" ON TRUE WHERE NOT "clients_projects_1"."_clients_projects_1" IS NULL`,
+		},
+		{
+			// combined with column filters, addressed by label
+			"projects",
+			"?select=id,c:clients(id)&or=(c.is.not_null,name.eq.x)",
+			`SELECT "public"."projects"."id",  row_to_json("projects_c_1".*) AS "c" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT "clients_1"."id" FROM "public"."clients" AS "clients_1" WHERE "clients_1"."id" = "public"."projects"."client_id") AS "projects_c_1" ON TRUE WHERE ("projects_c_1" IS DISTINCT FROM NULL OR "public"."projects"."name" = $1)`,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+	}
+
+	u, _ := url.Parse("?select=name,clients(*)&clients=eq.3")
+	if _, err := (PostgRestParser{}).parse("projects", u.Query()); err == nil {
+		t.Errorf("expected a parse error for a non-null filter on an embedded resource")
+	}
+	// embedding by column: the filter stays on the column
+	u, _ = url.Parse("?select=name,client:client_id(name)&client_id=eq.2")
+	parts, err := PostgRestParser{}.parse("projects", u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts.whereConditionsTree.children[0].embed != nil {
+		t.Errorf("client_id should be a column filter")
+	}
+}
+
+func TestSpreadToMany(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.clients": {
+				{Type: O2M, Table: "public.clients", Columns: []string{"id"}, RelatedTable: "public.projects", RelatedColumns: []string{"client_id"}},
+			},
+			"public.projects": {
+				{Type: M2M, Table: "public.projects", Columns: []string{"id"}, RelatedTable: "public.users", RelatedColumns: []string{"id"},
+					JunctionTable: "public.project_users", JColumns: []string{"project_id"}, JRelatedColumns: []string{"user_id"}},
+			},
+			"public.users": {
+				{Type: O2M, Table: "public.users", Columns: []string{"id"}, RelatedTable: "public.project_users", RelatedColumns: []string{"user_id"}},
+			},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+	}{
+		{
+			"clients",
+			"?select=name,...projects(project_name:name,id)",
+			`SELECT "public"."clients"."name",  COALESCE("clients_projects_1"."project_name", '[]') AS "project_name",  COALESCE("clients_projects_1"."id", '[]') AS "id" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_projects_1"."project_name") AS "project_name", json_agg("_clients_projects_1"."id") AS "id" FROM ( SELECT "projects_1"."name" AS "project_name", "projects_1"."id" FROM "public"."projects" AS "projects_1" WHERE "projects_1"."client_id" = "public"."clients"."id" ) AS "_clients_projects_1") AS "clients_projects_1" ON TRUE`,
+		},
+		{
+			// junction columns spread together with the related ones
+			"projects",
+			"?select=name,...users(user_name:name,...project_users!junction(role))",
+			`SELECT "public"."projects"."name",  COALESCE("projects_users_1"."user_name", '[]') AS "user_name",  COALESCE("projects_users_1"."role", '[]') AS "role" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT json_agg("_projects_users_1"."user_name") AS "user_name", json_agg("_projects_users_1"."role") AS "role" FROM ( SELECT "users_1"."name" AS "user_name", "public"."project_users"."role" FROM "public"."users" AS "users_1", "public"."project_users" WHERE "public"."project_users"."project_id" = "public"."projects"."id" AND "public"."project_users"."user_id" = "users_1"."id" ) AS "_projects_users_1") AS "projects_users_1" ON TRUE`,
+		},
+		{
+			// junction columns as an object
+			"projects",
+			"?select=name,users(name,project_users!junction(role))",
+			`SELECT "public"."projects"."name",  COALESCE("projects_users_1"."_projects_users_1", '[]') AS "users" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT json_agg("_projects_users_1") AS "_projects_users_1" FROM ( SELECT "users_1"."name",  json_build_object('role', "public"."project_users"."role") AS "project_users" FROM "public"."users" AS "users_1", "public"."project_users" WHERE "public"."project_users"."project_id" = "public"."projects"."id" AND "public"."project_users"."user_id" = "users_1"."id" ) AS "_projects_users_1") AS "projects_users_1" ON TRUE`,
+		},
+		{
+			// without the hint, the junction table is a to-many embed of users
+			"projects",
+			"?select=name,users(name,project_users(role))",
+			`SELECT "public"."projects"."name",  COALESCE("projects_users_1"."_projects_users_1", '[]') AS "users" FROM "public"."projects"  LEFT JOIN LATERAL ( SELECT json_agg("_projects_users_1") AS "_projects_users_1" FROM ( SELECT "users_1"."name",  COALESCE("users_project_users_2"."_users_project_users_2", '[]') AS "project_users" FROM "public"."users" AS "users_1", "public"."project_users"  LEFT JOIN LATERAL ( SELECT json_agg("_users_project_users_2") AS "_users_project_users_2" FROM ( SELECT "project_users_2"."role" FROM "public"."project_users" AS "project_users_2" WHERE "project_users_2"."user_id" = "users_1"."id" ) AS "_users_project_users_2") AS "users_project_users_2" ON TRUE WHERE "public"."project_users"."project_id" = "public"."projects"."id" AND "public"."project_users"."user_id" = "users_1"."id" ) AS "_projects_users_1") AS "projects_users_1" ON TRUE`,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+	}
+
+	for _, test := range []struct{ table, query string }{
+		{"clients", "?select=*,...projects(*)"},
+		{"clients", "?select=*,projects!junction(name)"},
+	} {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := (DirectQueryBuilder{}).BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info); err == nil {
+			t.Errorf("%s: expected a build error", test.query)
+		}
+	}
+}
+
+func TestComputedFields(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.clients": {
+				{Type: O2M, Table: "public.clients", Columns: []string{"id"}, RelatedTable: "public.people", RelatedColumns: []string{"client_id"}},
+			},
+		},
+		cachedComputedFields: map[string]map[string]Function{
+			"public.people": {"public.full_name": {Name: "full_name", Schema: "public", Returns: "text"}},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+	}{
+		{
+			"people",
+			"?select=id,full_name&full_name=ilike.*smith*&order=full_name.desc",
+			`SELECT "public"."people"."id", "public"."full_name"("people"::"public"."people") AS "full_name" FROM "public"."people" WHERE "public"."full_name"("people"::"public"."people") ILIKE $1 ORDER BY "public"."full_name"("people"::"public"."people") DESC`,
+		},
+		{
+			"people",
+			"?select=name:full_name::varchar",
+			`SELECT "public"."full_name"("people"::"public"."people")::varchar AS "name" FROM "public"."people"`,
+		},
+		{
+			// inside an embed
+			"clients",
+			"?select=id,people(full_name)&people.full_name=eq.Ann&people.order=full_name",
+			`SELECT "public"."clients"."id",  COALESCE("clients_people_1"."_clients_people_1", '[]') AS "people" FROM "public"."clients"  LEFT JOIN LATERAL ( SELECT json_agg("_clients_people_1") AS "_clients_people_1" FROM ( SELECT "public"."full_name"("people_1"::"public"."people") AS "full_name" FROM "public"."people" AS "people_1" WHERE "people_1"."client_id" = "public"."clients"."id" AND "public"."full_name"("people_1"::"public"."people") = 'Ann' ORDER BY "public"."full_name"("people_1"::"public"."people") ) AS "_clients_people_1") AS "clients_people_1" ON TRUE`,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+	}
+}
+
+func TestMultirangeOperators(t *testing.T) {
+	info := &SchemaInfo{
+		cachedColumnTypes: map[string]map[string]ColumnType{
+			"public.shifts": {
+				"hours":  {Name: "hours", Type: "int4multirange", IsMultirange: true, RangeType: "int4range"},
+				"period": {Name: "period", Type: "int4range"},
+			},
+		},
+	}
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"?hours=ov.[1,3)",
+			`SELECT * FROM "public"."shifts" WHERE "public"."shifts"."hours" && $1::int4range`,
+		},
+		{
+			"?hours=adj.(3,10]&period=cs.[1,2]",
+			`SELECT * FROM "public"."shifts" WHERE "public"."shifts"."hours" -|- $1::int4range AND "public"."shifts"."period" @> $2`,
+		},
+		{
+			// a multirange value is left to PostgreSQL
+			"?hours=cd.{[0,24)}",
+			`SELECT * FROM "public"."shifts" WHERE "public"."shifts"."hours" <@ $1`,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("shifts", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, _, err := DirectQueryBuilder{}.BuildSelect("shifts", parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+	}
+}
+
+func TestVectorDistances(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			"?select=id,embedding.cosine([0.1,-2,3e-5])&order=embedding.cosine([0.1,-2,3e-5])&limit=5",
+			`SELECT "public"."items"."id", ("public"."items"."embedding" <=> '[0.1,-2,3e-05]'::vector) AS "cosine" FROM "public"."items" ORDER BY ("public"."items"."embedding" <=> '[0.1,-2,3e-05]'::vector) LIMIT $1`,
+			[]any{int64(5)},
+		},
+		{
+			"?select=id,distance:embedding.l2([1, 2])&embedding=l2([1,2]).lt.0.5&order=embedding.l2([1,2]).desc",
+			`SELECT "public"."items"."id", ("public"."items"."embedding" <-> '[1,2]'::vector) AS "distance" FROM "public"."items" WHERE ("public"."items"."embedding" <-> $1) < $2 ORDER BY ("public"."items"."embedding" <-> '[1,2]'::vector) DESC`,
+			[]any{"[1,2]", "0.5"},
+		},
+		{
+			"?embedding=not.ip([1,2]).gte.-1&id=gt.3",
+			`SELECT * FROM "public"."items" WHERE NOT ("public"."items"."embedding" <#> $1) >= $2 AND "public"."items"."id" > $3`,
+			[]any{"[1,2]", "-1", "3"},
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("items", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("items", parts, &QueryOptions{Schema: "public"}, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	// the vector is validated, as orders embed it in the query
+	for _, query := range []string{
+		"?order=embedding.cosine([1,a])",
+		"?order=embedding.cosine([])",
+		"?embedding=cosine([1,2]).eq.0",
+		"?embedding=cosine([1,2]).lt",
+		"?embedding=not.ip([1,2]).gte",
+		"?select=embedding.l2(1)",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("items", u.Query()); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+
+	// the function arguments are the values that are not filters
+	filters := Filters{
+		"embedding": {"cosine([1,2]).lt.0.5"},
+		"body":      {"fts(english).cat"},
+		"host":      {"ip.example.org"},
+		"metric":    {"l2"},
+	}
+	args := PostgRestParser{}.filterParameters(filters)
+	if !reflect.DeepEqual(args, Filters{"host": {"ip.example.org"}, "metric": {"l2"}}) {
+		t.Errorf("unexpected arguments %v", args)
+	}
+	if len(filters) != 2 || filters["embedding"] == nil || filters["body"] == nil {
+		t.Errorf("unexpected filters %v", filters)
+	}
+}
+
+func TestSpatialFilters(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			`?geom=intersects.{"type":"Point","coordinates":[12.49,-41.8]}`,
+			`SELECT * FROM "public"."places" WHERE ST_Intersects("public"."places"."geom", ST_GeomFromGeoJSON($1))`,
+			[]any{`{"type":"Point","coordinates":[12.49,-41.8]}`},
+		},
+		{
+			`?geom=dwithin(1500.5)."SRID=4326%3BPOINT(12.49 41.89)"&id=gt.1`,
+			`SELECT * FROM "public"."places" WHERE ST_DWithin("public"."places"."geom", ST_GeomFromEWKT($1), $2) AND "public"."places"."id" > $3`,
+			[]any{"SRID=4326;POINT(12.49 41.89)", "1500.5", "1"},
+		},
+		{
+			"?geom=not.bbox.(12.4,41.8,12.6,42.0)",
+			`SELECT * FROM "public"."places" WHERE NOT "public"."places"."geom" && ST_MakeEnvelope($1, $2, $3, $4, $5)`,
+			[]any{"12.4", "41.8", "12.6", "42.0", "4326"},
+		},
+		{
+			"?geom=bbox(3857).(1,2,3,4)",
+			`SELECT * FROM "public"."places" WHERE "public"."places"."geom" && ST_MakeEnvelope($1, $2, $3, $4, $5)`,
+			[]any{"1", "2", "3", "4", "3857"},
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("places", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("places", parts, &QueryOptions{Schema: "public"}, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	for _, query := range []string{
+		"?geom=dwithin.{}",
+		"?geom=dwithin(far).{}",
+		"?geom=bbox.(1,2,3)",
+		"?geom=bbox(x).(1,2,3,4)",
+		"?geom=intersects(any).{}",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("places", u.Query()); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+
+	// GeoJSON features are built by PostGIS, before the count
+	u, _ := url.Parse("?select=id,geom&limit=10")
+	parts, _ := PostgRestParser{}.parse("places", u.Query())
+	options := &QueryOptions{Schema: "public", ContentType: "application/geo+json", Count: "exact"}
+	query, _, err := DirectQueryBuilder{}.BuildSelect("places", parts, options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `), Data AS (SELECT ST_AsGeoJSON(_geo.*)::text AS __feature FROM (SELECT "public"."places"."id", "public"."places"."geom" FROM "public"."places" LIMIT $1) AS _geo)`
+	if !strings.Contains(query, expected) {
+		t.Errorf("unexpected GeoJSON query %s", query)
+	}
+
+	// the geometry of a feature is the first geometry or geography column, known from the schema
+	info := &SchemaInfo{
+		cachedColumnTypes: map[string]map[string]ColumnType{
+			"public.places": {
+				"id":    {Name: "id", Type: "int4", Position: 1},
+				"area":  {Name: "area", Type: "geometry", Position: 4},
+				"point": {Name: "point", Type: "geography", Position: 3},
+			},
+		},
+	}
+	for _, test := range []struct{ query, column string }{
+		{"", "point"},
+		{"?select=id,area", "area"},
+		{"?select=id,where:point", "where"},
+	} {
+		u, _ := url.Parse(test.query)
+		parts, _ := PostgRestParser{}.parse("places", u.Query())
+		query, _, err := DirectQueryBuilder{}.BuildSelect("places", parts, &QueryOptions{Schema: "public", ContentType: "application/geo+json"}, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `SELECT json_build_object('type', 'Feature', 'geometry', ST_AsGeoJSON(_geo."` + test.column +
+			`"::geometry)::json, 'properties', to_jsonb(_geo.*) - '` + test.column + `')::text AS __feature FROM (`
+		if !strings.HasPrefix(query, expected) {
+			t.Errorf("%s: unexpected GeoJSON query %s", test.query, query)
+		}
+	}
+
+	// QueryWithJSON aggregates the rows in the database
+	if _, _, err := (QueryWithJSON{}).BuildSelect("places", &QueryParts{}, &QueryOptions{Schema: "public", ContentType: "application/geo+json"}, info); err == nil {
+		t.Error("expected an error with GeoJSON and QueryWithJSON")
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.customers": {
+				{Type: O2M, Table: "public.customers", Columns: []string{"id"}, RelatedTable: "public.orders", RelatedColumns: []string{"customer_id"}},
+			},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			"orders",
+			"?select=status,ids:id.array_agg(),codes:code.string_agg(', '),paid.bool_and(),paid.bool_or(),amount.stddev()",
+			`SELECT "public"."orders"."status", ARRAY_AGG("public"."orders"."id") AS "ids", STRING_AGG(("public"."orders"."code")::text, ', ') AS "codes", BOOL_AND("public"."orders"."paid") AS "bool_and", BOOL_OR("public"."orders"."paid") AS "bool_or", STDDEV("public"."orders"."amount") AS "stddev" FROM "public"."orders" GROUP BY "public"."orders"."status"`,
+			nil,
+		},
+		{
+			"orders",
+			"?select=code.string_agg(')'),closed:code.string_agg(\"(,)\")",
+			`SELECT STRING_AGG(("public"."orders"."code")::text, ')') AS "string_agg", STRING_AGG(("public"."orders"."code")::text, '(,)') AS "closed" FROM "public"."orders"`,
+			nil,
+		},
+		{
+			"orders",
+			"?select=median:amount.percentile_cont(0.50),amount.percentile_disc(.9)::int",
+			`SELECT PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY "public"."orders"."amount") AS "median", PERCENTILE_DISC(0.9) WITHIN GROUP (ORDER BY "public"."orders"."amount")::int AS "percentile_disc" FROM "public"."orders"`,
+			nil,
+		},
+		{
+			"orders",
+			"?select=customer_id,amount.sum()&status=eq.paid&having=(amount.sum().gt.1000,count().gte.2)",
+			`SELECT "public"."orders"."customer_id", SUM("public"."orders"."amount") AS "sum" FROM "public"."orders" WHERE "public"."orders"."status" = $1 GROUP BY "public"."orders"."customer_id" HAVING SUM("public"."orders"."amount") > $2 AND COUNT(*) >= $3`,
+			[]any{"paid", "1000", "2"},
+		},
+		{
+			"orders",
+			"?select=customer_id,count()&having=or(amount.percentile_cont(0.5).lt.10,amount.max().not.lt.100)",
+			`SELECT "public"."orders"."customer_id", COUNT(*) AS "count" FROM "public"."orders" GROUP BY "public"."orders"."customer_id" HAVING (PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY "public"."orders"."amount") < $1 OR NOT MAX("public"."orders"."amount") < $2)`,
+			[]any{"10", "100"},
+		},
+		{
+			"orders",
+			"?select=status,amount.sum()&having=status.neq.open",
+			`SELECT "public"."orders"."status", SUM("public"."orders"."amount") AS "sum" FROM "public"."orders" GROUP BY "public"."orders"."status" HAVING "public"."orders"."status" <> $1`,
+			[]any{"open"},
+		},
+		{
+			"orders",
+			"?select=amount.sum()&having=amount.sum().gt.10",
+			`SELECT SUM("public"."orders"."amount") AS "sum" FROM "public"."orders" HAVING SUM("public"."orders"."amount") > $1`,
+			[]any{"10"},
+		},
+		{
+			"customers",
+			"?select=name,orders(status,amount.sum())",
+			`SELECT "public"."customers"."name",  COALESCE("customers_orders_1"."_customers_orders_1", '[]') AS "orders" FROM "public"."customers"  LEFT JOIN LATERAL ( SELECT json_agg("_customers_orders_1") AS "_customers_orders_1" FROM ( SELECT "orders_1"."status", SUM("orders_1"."amount") AS "sum" FROM "public"."orders" AS "orders_1" WHERE "orders_1"."customer_id" = "public"."customers"."id" GROUP BY "orders_1"."status" ) AS "_customers_orders_1") AS "customers_orders_1" ON TRUE`,
+			nil,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	// the groups are counted, not the rows
+	u, _ := url.Parse("?select=customer_id,amount.sum()&having=(amount.sum().gt.100)&limit=10")
+	parts, err := PostgRestParser{}.parse("orders", u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _, err := DirectQueryBuilder{}.BuildSelect("orders", parts, &QueryOptions{Schema: "public", Count: "exact"}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `WITH Total AS (SELECT COUNT(*) AS __count FROM (SELECT "public"."orders"."customer_id", SUM("public"."orders"."amount") AS "sum" FROM "public"."orders" GROUP BY "public"."orders"."customer_id" HAVING SUM("public"."orders"."amount") > $1) AS __rows), Data AS (`
+	if !strings.HasPrefix(query, expected) {
+		t.Errorf("expected prefix\n\t%s\ngot\n\t%s", expected, query)
+	}
+
+	for _, query := range []string{
+		"?select=code.string_agg()",
+		"?select=amount.percentile_cont(2)",
+		"?select=amount.percentile_cont(NaN)",
+		"?select=amount.sum(1)",
+		"?select=amount.sum()&having=(amount.median().gt.1)",
+		"?select=customer_id&having=customer_id.gt.5",
+		"?having=amount.sum().gt.5",
+		"?select=customer_id,amount.sum()&having=or(amount.sum().gt.5,status.eq.paid)",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("orders", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
+
+func TestKeysetPagination(t *testing.T) {
+	info := &SchemaInfo{
+		cachedPrimaryKeys: map[string]Constraint{
+			"public.items": {Columns: []string{"id"}},
+		},
+	}
+	tests := []struct {
+		table    string
+		query    string
+		expected string
+		values   []any
+		cursor   int64 // page size of a full page, with a next cursor
+	}{
+		{
+			// first page
+			"items",
+			"?after=&limit=2",
+			`SELECT *, json_build_array("public"."items"."id")::text AS __cursor FROM "public"."items" ORDER BY "public"."items"."id" LIMIT $1`,
+			[]any{int64(2)},
+			2,
+		},
+		{
+			"items",
+			"?name=eq.x&order=created.desc&limit=2&after=" + encodeCursor(`["2024-05-01T10:00:00", 5]`),
+			`SELECT *, json_build_array("public"."items"."created", "public"."items"."id")::text AS __cursor FROM "public"."items" WHERE "public"."items"."name" = $1 AND ("public"."items"."created", "public"."items"."id") < ($2, $3) ORDER BY "public"."items"."created" DESC, "public"."items"."id" DESC LIMIT $4`,
+			[]any{"x", "2024-05-01T10:00:00", "5", int64(2)},
+			2,
+		},
+		{
+			// mixed directions, without limit there is no next cursor
+			"items",
+			"?order=name,created.desc&after=" + encodeCursor(`["x", "2024-05-01", 5]`),
+			`SELECT * FROM "public"."items" WHERE ("public"."items"."name" > $1 OR "public"."items"."name" = $1 AND ("public"."items"."created" < $2 OR "public"."items"."created" = $2 AND "public"."items"."id" < $3)) ORDER BY "public"."items"."name", "public"."items"."created" DESC, "public"."items"."id" DESC`,
+			[]any{"x", "2024-05-01", "5"},
+			0,
+		},
+		{
+			// a filter on a column named after
+			"items",
+			"?after=gt.5",
+			`SELECT * FROM "public"."items" WHERE "public"."items"."after" > $1`,
+			[]any{"5"},
+			0,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		options := &QueryOptions{Schema: "public"}
+		query, values, err := DirectQueryBuilder{}.BuildSelect(test.table, parts, options, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+		if options.cursorLimit != test.cursor {
+			t.Errorf("%d. expected cursor limit %d, got %d", i, test.cursor, options.cursorLimit)
+		}
+	}
+
+	errorTests := []struct {
+		table string
+		query string
+	}{
+		{"items", "?after=notacursor"},
+		{"items", "?after=" + encodeCursor(`[1, 2]`)},
+		{"items", "?after=" + encodeCursor(`[null]`)},
+		{"items", "?select=count()&after="},
+		{"notes", "?after="},
+	}
+	for i, test := range errorTests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		_, _, err = DirectQueryBuilder{}.BuildSelect(test.table, parts, &QueryOptions{Schema: "public"}, info)
+		if err == nil {
+			t.Errorf("%d. expected an error for %s", i, test.query)
+		}
+	}
+}
+
+func TestBuildExecuteVariadic(t *testing.T) {
+	info := &SchemaInfo{
+		cachedTypes: map[uint32]Type{0: {}},
+		cachedFunctions: map[string]Function{
+			"fn": {
+				Name:       "fn",
+				IsVariadic: true,
+				Arguments: []Argument{
+					{Name: "prefix", Mode: 'i'},
+					{Name: "v", Mode: 'v'},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		record Record
+		values []any
+	}{
+		{Record{"prefix": "p", "v": []string{"a", "b"}}, []any{"p", []string{"a", "b"}}}, // GET, repeated parameters
+		{Record{"prefix": "p", "v": []any{"a", "b"}}, []any{"p", []any{"a", "b"}}},       // POST, JSON array
+		{Record{"prefix": "p", "v": "a"}, []any{"p", []any{"a"}}},                        // single value
+	}
+	want := `SELECT * FROM "fn"("prefix" := $1, VARIADIC "v" := $2) t `
+	for i, test := range tests {
+		q, v, err := CommonBuilder{}.BuildExecute("fn", test.record, &QueryParts{}, &QueryOptions{}, info)
+		if err != nil {
+			t.Fatalf("%d. BuildExecute error: %v", i, err)
+		}
+		if q != want {
+			t.Errorf("%d. want: %s\n  got:  %s", i, want, q)
+		}
+		if !reflect.DeepEqual(v, test.values) {
+			t.Errorf("%d. values want: %v\n  got:  %v", i, test.values, v)
+		}
+	}
+}
+
+func TestDjangoParser(t *testing.T) {
+	// each Django query must produce the same SQL of the equivalent PostgREST query
+	tests := []struct {
+		django    string
+		postgrest string
+	}{
+		{
+			"?age__gte=18&name__icontains=bob&ordering=-created&fields=id,name",
+			"?age=gte.18&name=ilike.*bob*&order=created.desc&select=id,name",
+		},
+		{
+			"?name=bob&id__in=1,2,3&deleted__isnull=true&limit=10&offset=20",
+			"?name=eq.bob&id=in.(1,2,3)&deleted=is.null&limit=10&offset=20",
+		},
+		{
+			"?age__range=18,30&code__startswith=A&ordering=name,-age",
+			"?age=gte.18&age=lte.30&code=like.A*&order=name,age.desc",
+		},
+	}
+	for i, test := range tests {
+		u1, _ := url.Parse(test.django)
+		parts1, err := DjangoParser{}.parse("people", u1.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		q1, v1, err := DirectQueryBuilder{}.BuildSelect("people", parts1, &QueryOptions{Schema: "public"}, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		u2, _ := url.Parse(test.postgrest)
+		parts2, err := PostgRestParser{}.parse("people", u2.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		q2, v2, _ := DirectQueryBuilder{}.BuildSelect("people", parts2, &QueryOptions{Schema: "public"}, nil)
+		if q1 != q2 {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, q2, q1)
+		}
+		if !compareValues(v1, v2) {
+			t.Errorf("%d. expected values %v, got %v", i, v2, v1)
+		}
+	}
+
+	// LIKE wildcards in the values are literal
+	parts, err := DjangoParser{}.parse("people", Filters{"name__contains": {"50%_off"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := parts.whereConditionsTree.children[0].values[0]; v != `%50\%\_off%` {
+		t.Errorf("expected an escaped value, got %s", v)
+	}
+
+	// null and true are values, isnull apart
+	u, _ := url.Parse("?name=null&active__in=true,false&deleted__isnull=true")
+	parts, err = DjangoParser{}.parse("people", u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, values, _ := DirectQueryBuilder{}.BuildSelect("people", parts, &QueryOptions{Schema: "public"}, nil)
+	expected := `SELECT * FROM "public"."people" WHERE "public"."people"."active" IN ($1, $2) AND "public"."people"."deleted" IS null AND "public"."people"."name" = $3`
+	if query != expected {
+		t.Errorf("expected\n\t%s\ngot\n\t%s", expected, query)
+	}
+	if !compareValues(values, []any{"true", "false", "null"}) {
+		t.Errorf("expected bound values, got %v", values)
+	}
+
+	for _, query := range []string{
+		"?age__gte=18&limit=x",
+		"?deleted__isnull=maybe",
+		"?project__client__name=x",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (DjangoParser{}).parse("people", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
+
+func TestInsertMissingDefault(t *testing.T) {
+	info := &SchemaInfo{
+		cachedPrimaryKeys: map[string]Constraint{
+			"t": {Columns: []string{"id"}},
+		},
+	}
+	// single-key records, so that the column order is deterministic
+	records := []Record{{"id": 1}, {"name": "b"}, {"id": 3}}
+	tests := []struct {
+		options QueryOptions
+		query   string
+		values  []any
+	}{
+		{
+			QueryOptions{},
+			`INSERT INTO "t" ("id") VALUES ($1), ($2), ($3)`,
+			[]any{1, nil, 3},
+		},
+		{
+			QueryOptions{MissingDefault: true},
+			`INSERT INTO "t" ("id", "name") VALUES ($1, DEFAULT), (DEFAULT, $2), ($3, DEFAULT)`,
+			[]any{1, "b", 3},
+		},
+		{
+			QueryOptions{MissingDefault: true, MergeDuplicates: true},
+			`INSERT INTO "t" ("id", "name") VALUES ($1, DEFAULT), (DEFAULT, $2), ($3, DEFAULT) ON CONFLICT ("id") DO UPDATE SET "id" = EXCLUDED."id", "name" = EXCLUDED."name"`,
+			[]any{1, "b", 3},
+		},
+	}
+	for i, test := range tests {
+		q, v, err := CommonBuilder{}.BuildInsert("t", records, &QueryParts{}, &test.options, info)
+		if err != nil {
+			t.Fatalf("%d. BuildInsert error: %v", i, err)
+		}
+		if q != test.query {
+			t.Errorf("%d. want: %s\n  got:  %s", i, test.query, q)
+		}
+		if !reflect.DeepEqual(v, test.values) {
+			t.Errorf("%d. values want: %v\n  got:  %v", i, test.values, v)
+		}
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	tests := []struct {
+		prefer  []string
+		applied []string
+		invalid bool // with handling=strict
+	}{
+		{[]string{"return=representation, count=exact"}, []string{"return=representation", "count=exact"}, false},
+		{[]string{"missing=default", "tx=rollback"}, []string{"missing=default", "tx=rollback"}, false},
+		{[]string{"tx=commit, tx=rollback"}, []string{"tx=rollback"}, true},
+		{[]string{"resolution=merge-duplicates, resolution=ignore-duplicates"}, []string{"resolution=ignore-duplicates"}, true},
+		{[]string{"count=exact", "count=planned"}, []string{"count=planned"}, true},
+		{[]string{"return=minimal, timezone=UTC"}, []string{"return=minimal"}, true},
+		{[]string{"count=exact, count=exact"}, []string{"count=exact"}, false},
+		{[]string{"return=headers-only"}, []string{"return=headers-only"}, false},
+	}
+	for i, test := range tests {
+		for _, strict := range []bool{false, true} {
+			req := httptest.NewRequest("POST", "/", nil)
+			for _, p := range test.prefer {
+				req.Header.Add("Prefer", p)
+			}
+			applied := test.applied
+			if strict {
+				req.Header.Add("Prefer", "handling=strict")
+				applied = append(applied[:len(applied):len(applied)], "handling=strict")
+			}
+			options := PostgRestParser{}.getQueryOptions(req)
+			if !reflect.DeepEqual(options.PreferenceApplied, applied) {
+				t.Errorf("%d. applied want: %v\n  got:  %v", i, applied, options.PreferenceApplied)
+			}
+			if invalid := options.preferenceError != nil; invalid != (strict && test.invalid) {
+				t.Errorf("%d. strict %v: unexpected error %v", i, strict, options.preferenceError)
+			}
+		}
+	}
+
+	// only the preferences that affect the method are applied
+	for _, test := range []struct {
+		method, path string
+		applied      []string
+	}{
+		{"GET", "/t", []string{"tx=rollback", "count=exact"}},
+		{"HEAD", "/t", []string{"tx=rollback", "count=exact"}},
+		{"OPTIONS", "/t", []string{"tx=rollback"}},
+		{"POST", "/t", []string{"return=representation", "missing=default", "tx=rollback", "count=exact"}},
+		{"PATCH", "/t", []string{"return=representation", "tx=rollback", "count=exact", "unfiltered=allow"}},
+		// a table in a database named rpc
+		{"POST", "/rpc/t", []string{"return=representation", "missing=default", "tx=rollback", "count=exact"}},
+		{"POST", "/rpc/f", []string{"return=representation", "params=single-object", "tx=rollback", "count=exact"}},
+		{"GET", "/rpc/f", []string{"tx=rollback", "count=exact"}},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Add("Prefer", "return=representation, missing=default, params=single-object, tx=rollback, count=exact")
+		req.Header.Add("Prefer", "unfiltered=allow, handling=lenient")
+		applied := append(test.applied, "handling=lenient")
+		options := PostgRestParser{}.getQueryOptions(req)
+		if test.path == "/rpc/f" {
+			// the function routes
+			options.functionPreferences(test.method == "GET")
+		}
+		if !reflect.DeepEqual(options.PreferenceApplied, applied) {
+			t.Errorf("%s %s: applied want: %v\n  got:  %v", test.method, test.path, applied, options.PreferenceApplied)
+		}
+	}
+
+	// a preference that doesn't apply is dropped, or rejected with handling=strict
+	options := QueryOptions{ReturnRepresentation: true, PreferenceApplied: []string{"return=representation", "count=exact"}}
+	if err := options.dropPreference("return=representation", "void"); err != nil || !reflect.DeepEqual(options.PreferenceApplied, []string{"count=exact"}) {
+		t.Errorf("unexpected drop %v %v", err, options.PreferenceApplied)
+	}
+	options.HandlingStrict = true
+	if err := options.dropPreference("count=exact", "test"); err == nil {
+		t.Errorf("expected an error with handling=strict")
+	}
+}
+
+func TestWriteGuards(t *testing.T) {
+	// max-affected is honored only with handling=strict
+	for _, test := range []struct {
+		prefer string
+		has    bool
+		max    int64
+	}{
+		{"max-affected=5", false, 0},
+		{"handling=strict, max-affected=5", true, 5},
+		{"handling=strict, max-affected=-1", false, 0},
+	} {
+		req := httptest.NewRequest("PATCH", "/", nil)
+		req.Header.Add("Prefer", test.prefer)
+		options := PostgRestParser{}.getQueryOptions(req)
+		if options.HasMaxAffected != test.has || test.has && options.MaxAffected != test.max {
+			t.Errorf("%s: unexpected max-affected %v %v", test.prefer, options.HasMaxAffected, options.MaxAffected)
+		}
+	}
+	options := &QueryOptions{HasMaxAffected: true, MaxAffected: 2}
+	if checkMaxAffected(options, 2) != nil || checkMaxAffected(options, 3) == nil {
+		t.Errorf("unexpected max-affected check")
+	}
+
+	// unfiltered writes disabled in the configuration
+	saved := dbe
+	defer func() { dbe = saved }()
+	dbe = &DbEngine{config: &Config{UnfilteredWritesEnabled: false}}
+	parts, _ := PostgRestParser{}.parse("t", url.Values{})
+	if _, _, err := (CommonBuilder{}).BuildDelete("t", parts, &QueryOptions{}, nil); err == nil {
+		t.Errorf("expected an error for an unfiltered delete")
+	}
+	if _, _, err := (CommonBuilder{}).BuildUpdate("t", Record{"a": 1}, parts, &QueryOptions{}, nil); err == nil {
+		t.Errorf("expected an error for an unfiltered update")
+	}
+	if _, _, err := (CommonBuilder{}).BuildDelete("t", parts, &QueryOptions{AllowUnfiltered: true}, nil); err != nil {
+		t.Errorf("unexpected error with unfiltered=allow: %v", err)
+	}
+	parts, _ = PostgRestParser{}.parse("t", url.Values{"a": {"eq.1"}})
+	if _, _, err := (CommonBuilder{}).BuildDelete("t", parts, &QueryOptions{}, nil); err != nil {
+		t.Errorf("unexpected error with a filter: %v", err)
+	}
+}
+
+func TestInsertLocation(t *testing.T) {
+	info := &SchemaInfo{
+		cachedPrimaryKeys: map[string]Constraint{
+			"t": {Columns: []string{"name", "year"}},
+		},
+	}
+	options := &QueryOptions{}
+	q, _, err := CommonBuilder{}.BuildInsert("t", []Record{{"name": "Enzo"}}, &QueryParts{}, options, info)
+	if err != nil {
+		t.Fatalf("BuildInsert error: %v", err)
+	}
+	want := `INSERT INTO "t" ("name") VALUES ($1) RETURNING "name"::text, "year"::text`
+	if q != want || !reflect.DeepEqual(options.locationKeys, []string{"name", "year"}) {
+		t.Errorf("want: %s\n  got:  %s %v", want, q, options.locationKeys)
+	}
+	// no Location for many records or with the representation
+	for _, test := range []struct {
+		records []Record
+		options *QueryOptions
+	}{
+		{[]Record{{"name": "a"}, {"name": "b"}}, &QueryOptions{}},
+		{[]Record{{"name": "a"}}, &QueryOptions{ReturnRepresentation: true}},
+	} {
+		CommonBuilder{}.BuildInsert("t", test.records, &QueryParts{}, test.options, info)
+		if test.options.locationKeys != nil {
+			t.Errorf("unexpected location keys %v", test.options.locationKeys)
+		}
+	}
+	if l := locationQuery([]string{"name", "year"}, []string{"a&b c", "2021"}); l != "name=eq.a%26b+c&year=eq.2021" {
+		t.Errorf("unexpected location %s", l)
+	}
+}
+
+func TestBuildUpsert(t *testing.T) {
+	info := &SchemaInfo{
+		cachedPrimaryKeys: map[string]Constraint{
+			"employees": {Columns: []string{"first_name", "last_name"}},
+		},
+		cachedColumnTypes: map[string]map[string]ColumnType{
+			"employees": {"first_name": {}, "last_name": {}, "salary": {}},
+		},
+	}
+	record := Record{"first_name": "Susan", "last_name": "Heidt", "salary": float64(48000)}
+	tests := []struct {
+		query  string
+		record Record
+		result string
+		err    error
+	}{
+		{
+			"first_name=eq.Susan&last_name=eq.Heidt", record,
+			`INSERT INTO "employees" ("first_name", "last_name", "salary") VALUES ($1, $2, $3) ON CONFLICT ("first_name", "last_name") DO UPDATE SET "first_name" = EXCLUDED."first_name", "last_name" = EXCLUDED."last_name", "salary" = EXCLUDED."salary"`,
+			nil,
+		},
+		{"first_name=eq.Susan", record, "", &MethodError{}},
+		{"first_name=eq.Susan&last_name=eq.Heidt&salary=eq.48000", record, "", &MethodError{}},
+		{"first_name=eq.Susan&last_name=not.eq.Heidt", record, "", &MethodError{}},
+		{"first_name=eq.Susan&last_name=in.(Heidt)", record, "", &MethodError{}},
+		{"and=(first_name.eq.Susan,last_name.eq.Heidt)", record, "", &MethodError{}},
+		{"first_name=eq.Wendy&last_name=eq.Heidt", record, "", &BuildError{}},
+		{"first_name=eq.Susan&last_name=eq.Heidt", Record{"first_name": "Susan", "last_name": "Heidt"}, "", &BuildError{}},
+		{"first_name=eq.Susan&last_name=eq.Heidt&limit=1", record, "", &BuildError{}},
+	}
+	for i, test := range tests {
+		filters, _ := url.ParseQuery(test.query)
+		parts, err := PostgRestParser{}.parse("employees", filters)
+		if err != nil {
+			t.Fatalf("%d. parse error: %v", i, err)
+		}
+		q, _, err := CommonBuilder{}.BuildUpsert("employees", test.record, parts, &QueryOptions{}, info)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
+			t.Errorf("%d. want error %T, got %v", i, test.err, err)
+		}
+		if q != test.result {
+			t.Errorf("%d. want: %s\n  got:  %s", i, test.result, q)
+		}
+	}
+	// no primary key
+	parts, _ := PostgRestParser{}.parse("no_pk", url.Values{"a": {"eq.1"}})
+	if _, _, err := (CommonBuilder{}).BuildUpsert("no_pk", Record{"a": 1}, parts, &QueryOptions{}, info); err == nil {
+		t.Errorf("expected an error without primary key")
+	}
+}
+
+func TestPlanOptions(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		plan        string
+		options     []string
+	}{
+		{"application/vnd.pgrst.plan+json", "application/vnd.pgrst.plan+json", "json", nil},
+		{"application/vnd.pgrst.plan", "application/vnd.pgrst.plan+text", "text", nil},
+		{`application/vnd.pgrst.plan+text; options=analyze|buffers`, "application/vnd.pgrst.plan+text", "text", []string{"analyze", "buffers"}},
+		{`application/vnd.pgrst.plan+json; for="application/json"; options=verbose|verbose`, "application/vnd.pgrst.plan+json", "json", []string{"verbose"}},
+		{"application/vnd.pgrst.plan+json; options=analyze|costs", "unknown/unknown", "", nil},
+		{"*/*", "application/json", "", nil},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Add("Accept", test.accept)
+		options := PostgRestParser{}.getQueryOptions(req)
+		if options.ContentType != test.contentType || options.Plan != test.plan ||
+			!reflect.DeepEqual(options.PlanOptions, test.options) {
+			t.Errorf("%s: unexpected %q %q %v", test.accept, options.ContentType, options.Plan, options.PlanOptions)
+		}
+	}
+
+	// plans are allowed only to the configured roles
+	saved := dbe
+	defer func() { dbe = saved }()
+	dbe = &DbEngine{config: &Config{PlanRoles: []string{"admin"}}}
+	if !planEnabled("admin") || planEnabled("anon") {
+		t.Errorf("unexpected plan roles")
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	info := &SchemaInfo{
+		cachedRelationships: map[string][]Relationship{
+			"public.customers": {
+				{Type: O2M, Table: "public.customers", Columns: []string{"id"}, RelatedTable: "public.orders", RelatedColumns: []string{"customer_id"}},
+			},
+		},
+	}
+	tests := []struct {
+		builder  QueryBuilder
+		table    string
+		query    string
+		count    string
+		expected string
+		values   []any
+	}{
+		{
+			DirectQueryBuilder{},
+			"orders",
+			"?select=status&distinct",
+			"",
+			`SELECT DISTINCT "public"."orders"."status" FROM "public"."orders"`,
+			nil,
+		},
+		{
+			DirectQueryBuilder{},
+			"orders",
+			"?distinct=customer_id,data->>kind",
+			"",
+			`SELECT DISTINCT ON ("public"."orders"."customer_id", ("public"."orders"."data"->>'kind')) * FROM "public"."orders" ORDER BY "public"."orders"."customer_id", ("public"."orders"."data"->>'kind')`,
+			nil,
+		},
+		{
+			DirectQueryBuilder{},
+			"orders",
+			"?distinct=customer_id&order=created.desc,customer_id.desc&limit=10",
+			"exact",
+			`WITH Total AS (SELECT COUNT(*) AS __count FROM (SELECT DISTINCT ON ("public"."orders"."customer_id") * FROM "public"."orders") AS __rows), Data AS (SELECT DISTINCT ON ("public"."orders"."customer_id") * FROM "public"."orders" ORDER BY "public"."orders"."customer_id" DESC, "public"."orders"."created" DESC LIMIT $1),
+			PseudoRow AS (
+				SELECT 1 AS Dummy
+			)
+			SELECT 
+				__t.__count,
+				d.*
+			FROM PseudoRow
+			CROSS JOIN Total __t
+			LEFT JOIN Data d ON true;`,
+			[]any{int64(10)},
+		},
+		{
+			QueryWithJSON{},
+			"customers",
+			"?select=name,orders(status)&distinct=name&country=eq.IT",
+			"",
+			`SELECT json_agg(_rows) AS __json FROM (SELECT DISTINCT ON ("public"."customers"."name") "public"."customers"."name",  COALESCE("customers_orders_1"."_customers_orders_1", '[]') AS "orders" FROM "public"."customers"  LEFT JOIN LATERAL ( SELECT json_agg("_customers_orders_1") AS "_customers_orders_1" FROM ( SELECT "orders_1"."status" FROM "public"."orders" AS "orders_1" WHERE "orders_1"."customer_id" = "public"."customers"."id" ) AS "_customers_orders_1") AS "customers_orders_1" ON TRUE WHERE "public"."customers"."country" = $1 ORDER BY "public"."customers"."name") AS _rows`,
+			[]any{"IT"},
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse(test.table, u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := test.builder.BuildSelect(test.table, parts, &QueryOptions{Schema: "public", Count: test.count}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	for _, query := range []string{
+		"?distinct=customer_id.desc",
+		"?distinct=customer_id,",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("orders", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+	u, _ := url.Parse("?distinct&after=")
+	parts, _ := PostgRestParser{}.parse("orders", u.Query())
+	if _, _, err := (DirectQueryBuilder{}).BuildSelect("orders", parts, &QueryOptions{Schema: "public"}, info); err == nil {
+		t.Errorf("expected an error for keyset pagination with distinct")
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	info := &SchemaInfo{
+		cachedColumnTypes: map[string]map[string]ColumnType{
+			"public.posts": {
+				"body":     {Name: "body", Type: "text"},
+				"document": {Name: "document", Type: "tsvector"},
+			},
+		},
+	}
+	tests := []struct {
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			"?select=id&body=wfts(english).cat dog&order=body.rank(wfts(english).cat dog).desc",
+			`SELECT "public"."posts"."id" FROM "public"."posts" WHERE to_tsvector('english', "public"."posts"."body") @@ websearch_to_tsquery('english', $1) ORDER BY ts_rank(to_tsvector('english', "public"."posts"."body"), websearch_to_tsquery('english', 'cat dog')) DESC`,
+			[]any{"cat dog"},
+		},
+		{
+			"?select=id,score:document.rank_cd(fts.(cat|dog) %26 bird:*)&order=document.rank_cd(fts.(cat|dog) %26 bird:*).desc,id",
+			`SELECT "public"."posts"."id", ts_rank_cd("public"."posts"."document", to_tsquery('(cat|dog)& bird:*')) AS "score" FROM "public"."posts" ORDER BY ts_rank_cd("public"."posts"."document", to_tsquery('(cat|dog)& bird:*')) DESC, "public"."posts"."id"`,
+			nil,
+		},
+		{
+			`?select=id,body.headline(wfts(simple)."cat dog" -it\'s)`,
+			`SELECT "public"."posts"."id", ts_headline('simple', "public"."posts"."body", websearch_to_tsquery('simple', '"cat dog" -it''s')) AS "headline" FROM "public"."posts"`,
+			nil,
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("posts", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("posts", parts, &QueryOptions{Schema: "public"}, info)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	for _, query := range []string{
+		"?order=body.headline(wfts.cat)",
+		"?order=body.rank(like.cat)",
+		"?order=body.rank(wfts.cat",
+		"?select=body.rank(wfts.)",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("posts", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
+
+func TestJsonPathFilters(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			"?doc=jpexists.$.items[*] ? (@.qty > 5)",
+			`SELECT * FROM "public"."events" WHERE "public"."events"."doc" @? $1::jsonpath`,
+			[]any{"$.items[*]? (@.qty > 5)"},
+		},
+		{
+			`?doc=not.jpmatch.$.items[0].name like_regex "^a,b" flag "i"`,
+			`SELECT * FROM "public"."events" WHERE NOT "public"."events"."doc" @@ $1::jsonpath`,
+			[]any{`$.items[0].name like_regex "^a,b" flag "i"`},
+		},
+		{
+			"?or=(doc.jpexists.$.tags[1,2],payload->meta.jpmatch.$.size == 1.5)",
+			`SELECT * FROM "public"."events" WHERE ("public"."events"."doc" @? $1::jsonpath OR ("public"."events"."payload"->'meta') @@ $2::jsonpath)`,
+			[]any{"$.tags[1,2]", "$.size ==1.5"},
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("events", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("events", parts, &QueryOptions{Schema: "public"}, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	for _, query := range []string{
+		"?doc=jpexists.",
+		"?doc=jpexists",
+		"?doc=jpmatch",
+		"?doc=not.jpexists",
+		"?or=(doc.jpmatch)",
+		"?doc=jpexists.$.a[1",
+		"?doc=jpmatch(english).$.a",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("events", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		values   []any
+	}{
+		{
+			"?select=id,name,name.similar(bob smith)&name=sim.bob smith&order=name.similar(bob smith),id&limit=10",
+			`SELECT "public"."people"."id", "public"."people"."name", similarity("public"."people"."name", 'bob smith') AS "name_similarity" FROM "public"."people" WHERE "public"."people"."name" % $1 ORDER BY ("public"."people"."name" <-> 'bob smith'), "public"."people"."id" LIMIT $2`,
+			[]any{"bob smith", int64(10)},
+		},
+		{
+			`?select=score:name.similar(o'brien, j),nick.similar((bob))&or=(name.wsim.bob,nick.sim.bob)&order=nick.similar( bob ).desc`,
+			`SELECT similarity("public"."people"."name", 'o''brien, j') AS "score", similarity("public"."people"."nick", '(bob)') AS "nick_similarity" FROM "public"."people" WHERE ("public"."people"."name" %> $1 OR "public"."people"."nick" % $2) ORDER BY ("public"."people"."nick" <-> ' bob ') DESC`,
+			[]any{"bob", "bob"},
+		},
+	}
+	for i, test := range tests {
+		u, _ := url.Parse(test.query)
+		parts, err := PostgRestParser{}.parse("people", u.Query())
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		query, values, err := DirectQueryBuilder{}.BuildSelect("people", parts, &QueryOptions{Schema: "public"}, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if query != test.expected {
+			t.Errorf("%d. expected\n\t%s\ngot\n\t%s", i, test.expected, query)
+		}
+		if !compareValues(values, test.values) {
+			t.Errorf("%d. expected values %v, got %v", i, test.values, values)
+		}
+	}
+
+	for _, query := range []string{
+		"?order=name.similar()",
+		"?order=name.similar(bob",
+	} {
+		u, _ := url.Parse(query)
+		if _, err := (PostgRestParser{}).parse("people", u.Query()); err == nil {
+			t.Errorf("%s: expected a parse error", query)
+		}
+	}
+}
@@ -2,6 +2,16 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/samber/lo"
 )
 
 type RangeError struct {
@@ -26,106 +36,419 @@ func querySerialize(ctx context.Context, query string, values []any) ([]byte, in
 		return nil, 0, &RangeError{msg: "Requested range not satisfiable"}
 	}
 	info := gi.Db.info.Load()
-	rows, err := gi.Conn.Query(ctx, query, values...)
+	start := time.Now()
+	pgrows, err := gi.Conn.Query(ctx, query, values...)
 	if err != nil {
+		gi.track("query", start)
 		return nil, 0, err
 	}
-	defer rows.Close()
+	defer pgrows.Close()
+	timed := newTimedRows(gi, pgrows, start)
+	defer timed.done()
+	var rows pgx.Rows = timed
+	var cursors *cursorRows
+	if options.cursorLimit > 0 {
+		cursors = &cursorRows{Rows: rows}
+		rows = cursors
+	}
 	var serializer TextSerializer
 	switch options.ContentType {
 	case "text/csv":
 		serializer = &CSVSerializer{}
 	case "application/octet-stream":
 		serializer = &BinarySerializer{}
+	case "application/geo+json":
+		serializer = &GeoJSONSerializer{}
 	default:
 		serializer = gi.QueryBuilder.preferredSerializer()
 	}
-	return serializer.Serialize(rows, false, options.Singular, info)
+	data, count, err := serializer.Serialize(rows, false, options.Singular, info)
+	if err == nil && cursors != nil && cursors.rows == options.cursorLimit {
+		// only a full page has a next one
+		options.NextCursor = encodeCursor(cursors.cursor)
+	}
+	return data, count, err
+}
+
+// cursorRows hides the __cursor column that ends the rows of a keyset pagination,
+// keeping the cursor of the last row
+type cursorRows struct {
+	pgx.Rows
+	rows   int64  // rows with a cursor, the row with only the count has none
+	cursor string // cursor of the last row
+}
+
+func (r *cursorRows) Next() bool {
+	if !r.Rows.Next() {
+		return false
+	}
+	raw := r.Rows.RawValues()
+	if c := raw[len(raw)-1]; c != nil {
+		r.rows++
+		r.cursor = string(c)
+	}
+	return true
+}
+
+func (r *cursorRows) FieldDescriptions() []pgconn.FieldDescription {
+	fds := r.Rows.FieldDescriptions()
+	return fds[:len(fds)-1]
+}
+
+func (r *cursorRows) RawValues() [][]byte {
+	raw := r.Rows.RawValues()
+	return raw[:len(raw)-1]
+}
+
+func (r *cursorRows) Values() ([]any, error) {
+	values, err := r.Rows.Values()
+	if err != nil {
+		return nil, err
+	}
+	return values[:len(values)-1], nil
+}
+
+// explainNode is the part of an EXPLAIN (FORMAT JSON) plan node needed for row estimates
+type explainNode struct {
+	NodeType string        `json:"Node Type"`
+	PlanRows int64         `json:"Plan Rows"`
+	Plans    []explainNode `json:"Plans"`
+}
+
+// planRows extracts the estimated number of rows from the output of EXPLAIN (FORMAT JSON).
+// Limit nodes are skipped, so that the estimate covers the whole result and not only the requested page.
+func planRows(plan []byte) (int64, error) {
+	var explain []struct {
+		Plan explainNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &explain); err != nil {
+		return 0, err
+	}
+	if len(explain) == 0 {
+		return 0, nil
+	}
+	node := explain[0].Plan
+	for node.NodeType == "Limit" && len(node.Plans) > 0 {
+		node = node.Plans[0]
+	}
+	return node.PlanRows, nil
+}
+
+// plannedCount asks the planner for the number of rows the query would return
+func plannedCount(ctx context.Context, query string, values []any) (int64, error) {
+	gi := GetSmoothContext(ctx)
+	var plan []byte
+	defer gi.track("query", time.Now())
+	err := gi.Conn.QueryRow(ctx, "EXPLAIN (FORMAT JSON) "+query, values...).Scan(&plan)
+	if err != nil {
+		return 0, err
+	}
+	return planRows(plan)
+}
+
+// resolveCount applies the requested count strategy to the count obtained running the query.
+// count=planned always uses the planner estimate, count=estimated only when the capped exact
+// count goes past the configured threshold.
+func resolveCount(ctx context.Context, query string, values []any, count int64) (int64, error) {
+	options := GetQueryOptions(ctx)
+	switch options.Count {
+	case "planned":
+		if options.estimateQuery != "" {
+			query = options.estimateQuery
+		}
+		return plannedCount(ctx, query, values)
+	case "estimated":
+		if options.estimateQuery == "" || count <= int64(estimatedCountThreshold()) {
+			// every row was counted, the count is exact
+			return count, nil
+		}
+		estimate, err := plannedCount(ctx, options.estimateQuery, values)
+		if err != nil {
+			return 0, err
+		}
+		return max(count, estimate), nil
+	}
+	return count, nil
 }
 
 func Select(ctx context.Context, table string, filters Filters) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
+	if err := gi.QueryOptions.preferenceError; err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
 	options := &gi.QueryOptions
+	start = time.Now()
 	query, values, err := gi.QueryBuilder.BuildSelect(table, parts, options, gi.Db.info.Load())
+	gi.track("build", start)
+	if err != nil {
+		return nil, 0, err
+	}
+	if options.Plan != "" {
+		return explain(ctx, query, values)
+	}
+	data, count, err := querySerialize(ctx, query, values)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err = resolveCount(ctx, query, values, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, count, nil
+}
+
+// planEnabled reports whether the role can get execution plans
+func planEnabled(role string) bool {
+	return dbe != nil && lo.Contains(dbe.config.PlanRoles, role)
+}
+
+// explain returns the execution plan of a built query, in the format
+// requested with the plan media types.
+// It runs with the role of the request, so the plan includes its RLS policies.
+// With analyze the query is executed, so its effects (volatile functions,
+// writes) are always rolled back.
+func explain(ctx context.Context, query string, values []any) (data []byte, count int64, err error) {
+	gi := GetSmoothContext(ctx)
+	options := &gi.QueryOptions
+	if !planEnabled(gi.Role) {
+		return nil, 0, &ContentTypeError{msg: "Execution plans are not enabled for the role"}
+	}
+	if lo.Contains(options.PlanOptions, "analyze") {
+		// a savepoint when a request transaction is already open
+		begin, rollback := "BEGIN", "ROLLBACK"
+		if gi.Conn.PgConn().TxStatus() != 'I' {
+			begin, rollback = "SAVEPOINT __explain", "ROLLBACK TO SAVEPOINT __explain; RELEASE SAVEPOINT __explain"
+		}
+		if _, err = gi.Conn.Exec(ctx, begin); err != nil {
+			return nil, 0, err
+		}
+		defer func() {
+			if _, rerr := gi.Conn.Exec(ctx, rollback); err == nil {
+				err = rerr
+			}
+		}()
+	}
+	var sb strings.Builder
+	sb.WriteString("EXPLAIN (FORMAT ")
+	sb.WriteString(strings.ToUpper(options.Plan))
+	for _, o := range options.PlanOptions {
+		sb.WriteString(", ")
+		sb.WriteString(strings.ToUpper(o))
+	}
+	sb.WriteString(") ")
+	sb.WriteString(query)
+	defer gi.track("query", time.Now())
+	rows, err := gi.Conn.Query(ctx, sb.String(), values...)
+	if err != nil {
+		return nil, 0, err
+	}
+	lines, err := pgx.CollectRows(rows, pgx.RowTo[string])
 	if err != nil {
 		return nil, 0, err
 	}
-	return querySerialize(ctx, query, values)
+	return []byte(strings.Join(lines, "\n")), 0, nil
 }
 
 func Insert(ctx context.Context, table string, records []Record, filters Filters) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
+	if err := gi.QueryOptions.preferenceError; err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
 	options := &gi.QueryOptions
+	start = time.Now()
 	insert, values, err := gi.QueryBuilder.BuildInsert(table, records, parts, options, gi.Db.info.Load())
+	gi.track("build", start)
 	if err != nil {
 		return nil, 0, err
 	}
-	if options.ReturnRepresentation {
-		return querySerialize(ctx, insert, values)
-	} else {
-		tag, err := gi.Conn.Exec(ctx, insert, values...)
-		if err != nil {
-			return nil, 0, err
-		}
-		return nil, tag.RowsAffected(), nil
+	if len(options.locationKeys) > 0 {
+		return insertLocation(ctx, insert, values)
+	}
+	return execWrite(ctx, insert, values)
+}
+
+// insertLocation runs a single-row insert returning the primary key, and keeps
+// in the Location option the query string that selects the created row
+func insertLocation(ctx context.Context, query string, values []any) ([]byte, int64, error) {
+	gi := GetSmoothContext(ctx)
+	options := &gi.QueryOptions
+	keys := make([]string, len(options.locationKeys))
+	dest := make([]any, len(keys))
+	for i := range keys {
+		dest[i] = &keys[i]
+	}
+	start := time.Now()
+	err := gi.Conn.QueryRow(ctx, query, values...).Scan(dest...)
+	gi.track("query", start)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// ignored duplicate
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
 	}
+	options.Location = locationQuery(options.locationKeys, keys)
+	return nil, 1, nil
 }
 
 func Update(ctx context.Context, table string, record Record, filters Filters) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
+	if err := gi.QueryOptions.preferenceError; err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
 	options := &gi.QueryOptions
+	start = time.Now()
 	update, values, err := gi.QueryBuilder.BuildUpdate(table, record, parts, options, gi.Db.info.Load())
+	gi.track("build", start)
 	if err != nil {
 		return nil, 0, err
 	}
-	if options.ReturnRepresentation {
-		return querySerialize(ctx, update, values)
-	} else {
-		tag, err := gi.Conn.Exec(ctx, update, values...)
-		if err != nil {
-			return nil, 0, err
-		}
-		return nil, tag.RowsAffected(), nil
-	}
+	return execGuarded(ctx, update, values)
 }
 
 func Delete(ctx context.Context, table string, filters Filters) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
+	if err := gi.QueryOptions.preferenceError; err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
 	options := &gi.QueryOptions
+	start = time.Now()
 	delete, values, err := gi.QueryBuilder.BuildDelete(table, parts, options, gi.Db.info.Load())
+	gi.track("build", start)
 	if err != nil {
 		return nil, 0, err
 	}
-	if options.ReturnRepresentation {
-		return querySerialize(ctx, delete, values)
-	} else {
-		tag, err := gi.Conn.Exec(ctx, delete, values...)
-		if err != nil {
+	return execGuarded(ctx, delete, values)
+}
+
+// Upsert creates or replaces the single row of a PUT, selected by its primary key
+func Upsert(ctx context.Context, table string, records []Record, filters Filters) ([]byte, int64, error) {
+	gi := GetSmoothContext(ctx)
+	if err := gi.QueryOptions.preferenceError; err != nil {
+		return nil, 0, err
+	}
+	if len(records) != 1 {
+		return nil, 0, &BuildError{"PUT requires a single row in the payload"}
+	}
+	start := time.Now()
+	parts, err := gi.RequestParser.parse(table, filters)
+	gi.track("parse", start)
+	if err != nil {
+		return nil, 0, err
+	}
+	options := &gi.QueryOptions
+	start = time.Now()
+	upsert, values, err := gi.QueryBuilder.BuildUpsert(table, records[0], parts, options, gi.Db.info.Load())
+	gi.track("build", start)
+	if err != nil {
+		return nil, 0, err
+	}
+	return execWrite(ctx, upsert, values)
+}
+
+// execWrite runs a data-modifying statement, returning the resulting rows with return=representation
+func execWrite(ctx context.Context, query string, values []any) ([]byte, int64, error) {
+	gi := GetSmoothContext(ctx)
+	if gi.QueryOptions.ReturnRepresentation {
+		return querySerialize(ctx, query, values)
+	}
+	start := time.Now()
+	tag, err := gi.Conn.Exec(ctx, query, values...)
+	gi.track("query", start)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nil, tag.RowsAffected(), nil
+}
+
+// execGuarded runs an UPDATE or DELETE with execWrite. With max-affected, it runs
+// in a transaction, rolled back when the statement changes more rows than allowed.
+func execGuarded(ctx context.Context, query string, values []any) (data []byte, count int64, err error) {
+	gi := GetSmoothContext(ctx)
+	options := &gi.QueryOptions
+	if !options.HasMaxAffected {
+		return execWrite(ctx, query, values)
+	}
+	// When a request transaction is already open we join it; an error will
+	// roll it back on release (http error).
+	ownTx := gi.Conn.PgConn().TxStatus() == 'I'
+	if ownTx {
+		if _, err = gi.Conn.Exec(ctx, "BEGIN"); err != nil {
+			return nil, 0, err
+		}
+		defer func() {
+			if err != nil {
+				gi.Conn.Exec(ctx, "ROLLBACK")
+			}
+		}()
+	}
+	data, count, err = execWrite(ctx, query, values)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = checkMaxAffected(options, count); err != nil {
+		return nil, 0, err
+	}
+	if ownTx {
+		if _, err = gi.Conn.Exec(ctx, "COMMIT"); err != nil {
 			return nil, 0, err
 		}
-		return nil, tag.RowsAffected(), nil
 	}
+	return data, count, nil
+}
+
+// locationQuery returns the query string selecting a row by its primary key
+func locationQuery(columns, keys []string) string {
+	var query string
+	for i, col := range columns {
+		if i != 0 {
+			query += "&"
+		}
+		query += url.QueryEscape(col) + "=eq." + url.QueryEscape(keys[i])
+	}
+	return query
+}
+
+// checkMaxAffected returns an error if more rows than allowed by max-affected have been changed
+func checkMaxAffected(options *QueryOptions, count int64) error {
+	if options.HasMaxAffected && count > options.MaxAffected {
+		return &BuildError{"the request affects " + strconv.FormatInt(count, 10) +
+			" rows, more than max-affected=" + strconv.FormatInt(options.MaxAffected, 10)}
+	}
+	return nil
 }
 
 func Execute(ctx context.Context, function string, record Record, filters Filters, readonly bool) ([]byte, int64, error) {
 	gi := GetSmoothContext(ctx)
 	options := &gi.QueryOptions
+	if options.preferenceError != nil {
+		return nil, 0, options.preferenceError
+	}
+	options.functionPreferences(readonly)
 	if options.ContentType == "unknown/unknown" {
 		return nil, 0, &ContentTypeError{msg: "Content type not available"}
 	}
@@ -133,33 +456,53 @@ func Execute(ctx context.Context, function string, record Record, filters Filter
 	if readonly {
 		params = gi.RequestParser.filterParameters(filters)
 	}
+	start := time.Now()
 	parts, err := gi.RequestParser.parse(function, filters)
+	gi.track("parse", start)
 	if err != nil {
 		return nil, 0, err
 	}
+	info := gi.Db.info.Load()
+	f := info.GetFunction(_s(function, options.Schema))
+	if f != nil && f.Returns == "void" && options.ReturnRepresentation {
+		if err := options.dropPreference("return=representation", "the function returns void"); err != nil {
+			return nil, 0, err
+		}
+	}
 	if readonly {
 		if len(record) != 0 {
 
 		}
 		record = make(map[string]any)
+		variadic := variadicArgument(f)
 		for k, vv := range params {
+			if k == variadic {
+				// repeated parameters fill the variadic argument (?v=a&v=b)
+				record[k] = vv
+				continue
+			}
 			for _, v := range vv {
 				record[k] = v
 			}
 		}
 	}
-	info := gi.Db.info.Load()
+	start = time.Now()
 	exec, values, err := gi.QueryBuilder.BuildExecute(function, record, parts, options, info)
+	gi.track("build", start)
 	if err != nil {
 		return nil, 0, err
 	}
-	rows, err := gi.Conn.Query(ctx, exec, values...)
+	if options.Plan != "" {
+		return explain(ctx, exec, values)
+	}
+	start = time.Now()
+	pgrows, err := gi.Conn.Query(ctx, exec, values...)
 	if err != nil {
+		gi.track("query", start)
 		return nil, 0, err
 	}
-	defer rows.Close()
+	rows := newTimedRows(gi, pgrows, start)
 	var scalar bool
-	f := info.GetFunction(_s(function, options.Schema))
 	if f != nil {
 		rettype := info.GetTypeById(f.ReturnTypeId)
 		if rettype != nil {
@@ -173,8 +516,21 @@ func Execute(ctx context.Context, function string, record Record, filters Filter
 		serializer = &CSVSerializer{}
 	case "application/octet-stream":
 		serializer = &BinarySerializer{}
+	case "application/geo+json":
+		serializer = &GeoJSONSerializer{}
 	default:
 		serializer = gi.QueryBuilder.preferredSerializer()
 	}
-	return serializer.Serialize(rows, scalar, single, info)
+	data, count, err := serializer.Serialize(rows, scalar, single, info)
+	rows.done()
+	// the connection must be free before asking for the plan
+	rows.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err = resolveCount(ctx, exec, values, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, count, nil
 }
@@ -0,0 +1,28 @@
+package database
+
+import "testing"
+
+func TestPlanRows(t *testing.T) {
+	tests := []struct {
+		plan string
+		rows int64
+	}{
+		{`[{"Plan": {"Node Type": "Seq Scan", "Plan Rows": 1250}}]`, 1250},
+		// the page size is not the estimate: Limit nodes are skipped
+		{`[{"Plan": {"Node Type": "Limit", "Plan Rows": 10, "Plans": [{"Node Type": "Index Scan", "Plan Rows": 98000}]}}]`, 98000},
+		{`[{"Plan": {"Node Type": "Aggregate", "Plan Rows": 3, "Plans": [{"Node Type": "Seq Scan", "Plan Rows": 40}]}}]`, 3},
+		{`[]`, 0},
+	}
+	for i, test := range tests {
+		rows, err := planRows([]byte(test.plan))
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+		if rows != test.rows {
+			t.Errorf("%d. expected %d rows, got %d", i, test.rows, rows)
+		}
+	}
+	if _, err := planRows([]byte(`not json`)); err == nil {
+		t.Error("expected an error for a malformed plan")
+	}
+}
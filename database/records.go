@@ -18,6 +18,10 @@ func UpdateRecords(ctx context.Context, table string, record Record, filters Fil
 	return Update(ctx, table, record, filters)
 }
 
+func UpsertRecords(ctx context.Context, table string, records []Record, filters Filters) ([]byte, int64, error) {
+	return Upsert(ctx, table, records, filters)
+}
+
 func DeleteRecords(ctx context.Context, table string, filters Filters) ([]byte, int64, error) {
 	return Delete(ctx, table, filters)
 }
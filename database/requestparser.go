@@ -1,13 +1,17 @@
 package database
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"unicode"
+
+	"github.com/samber/lo"
 )
 
 type Filters = url.Values
@@ -31,12 +35,17 @@ type Field struct {
 //
 // label is used as an alias both for a field and a relation.
 type SelectField struct {
-	field     Field           // field info
-	label     string          // label for the field or the nested relation
-	cast      string          // type cast for the field (before aggregation)
-	aggregate string          // aggregate function: avg, count, max, min, sum
-	aggCast   string          // type cast for the aggregate result (after aggregation)
-	relation  *SelectRelation // relation (can be null)
+	field      Field           // field info
+	label      string          // label for the field or the nested relation
+	cast       string          // type cast for the field (before aggregation)
+	aggregate  string          // aggregate function: avg, count, max, min, sum, array_agg, ...
+	aggArg     string          // argument of the aggregate: string_agg delimiter, percentile fraction
+	aggCast    string          // type cast for the aggregate result (after aggregation)
+	relation   *SelectRelation // relation (can be null)
+	distance   string          // pgvector distance operator from the field to vector (<->, <=>, <#>)
+	vector     string          // pgvector value for distance
+	textSearch *TextSearch     // full-text search rank or headline: body.headline(wfts(english).cat dog)
+	similar    string          // pg_trgm similarity of the field to this text: name.similar(bob)
 }
 
 // SelectRelation stores information about a relationship, expressed in the select clause like:
@@ -48,6 +57,8 @@ type SelectRelation struct {
 	inner  bool          // is an inner join?
 	fk     string        //
 	fields []SelectField // requested fields
+	limit  string        // limit for the embedded rows (rel.limit=n)
+	offset string        // offset for the embedded rows (rel.offset=n)
 }
 
 type OrderField struct {
@@ -55,6 +66,19 @@ type OrderField struct {
 	relation    string // for related orders: the relation/label name (empty for regular orders)
 	descending  bool
 	invertNulls bool
+	distance    string      // pgvector distance operator from the field to vector: order=embedding.cosine([1,2])
+	vector      string      // pgvector value for distance
+	textSearch  *TextSearch // full-text search rank: order=body.rank(wfts(english).cat dog)
+	similar     string      // pg_trgm distance of the field to this text: order=name.similar(bob)
+}
+
+// TextSearch is a full-text search query, with the syntax of the FTS filters,
+// to rank the rows or to highlight the matches
+type TextSearch struct {
+	function string // ts_rank, ts_rank_cd or ts_headline
+	operator string // fts, plfts, phfts or wfts
+	language string // text search configuration (can be empty)
+	query    string
 }
 
 type WhereConditionNode struct {
@@ -63,9 +87,15 @@ type WhereConditionNode struct {
 	opSource   string
 	opArgs     []string
 	opModifier string // "any" or "all" modifier for operators like eq(any), like(all), etc.
+	distance   string // pgvector distance operator from the field to vector, compared with the values
+	vector     string // pgvector value for distance: embedding=cosine([1,2]).lt.0.5
+	aggregate  string // aggregate function applied to the field, in the having tree: amount.sum().gt.100
+	aggArg     string // argument of the aggregate
 	not        bool
 	values     []string
+	bound      bool // values are always parameters, even null or true: the Django lookups have no keywords
 	inserted   bool
+	embed      *SelectField // embedded resource tested by a null filter (rel=is.null), nil for column filters
 	children   []*WhereConditionNode
 }
 
@@ -92,22 +122,29 @@ type RecursiveInfo struct {
 
 // QueryParts is the root of the AST produced by the request parser
 type QueryParts struct {
-	selectFields        []SelectField
-	columnFields        map[string]struct{}
-	conflictFields      map[string]struct{}
-	orderFields         []OrderField
-	limit               string
-	offset              string
-	whereConditionsTree *WhereConditionNode
-	recursive           *RecursiveInfo
+	selectFields         []SelectField
+	columnFields         map[string]struct{}
+	conflictFields       map[string]struct{}
+	orderFields          []OrderField
+	distinct             bool         // SELECT DISTINCT, requested with distinct
+	distinctFields       []OrderField // DISTINCT ON fields, requested with distinct=f1,f2
+	limit                string
+	offset               string
+	keyset               bool   // keyset pagination, requested with after (empty for the first page)
+	after                string // opaque cursor of the last row of the previous page
+	whereConditionsTree  *WhereConditionNode
+	havingConditionsTree *WhereConditionNode // conditions on the aggregates: having=(amount.sum().gt.100)
+	recursive            *RecursiveInfo
 }
 
 type QueryOptions struct {
 	Schema               string
 	ContentType          string // json, csv
 	ReturnRepresentation bool
+	ReturnHeadersOnly    bool // return=headers-only: no body, the count in Content-Range
 	MergeDuplicates      bool
 	IgnoreDuplicates     bool
+	MissingDefault       bool // missing columns in inserted records take their default instead of NULL
 	ParamsAsSingleObject bool
 	TxCommit             bool
 	TxRollback           bool
@@ -115,14 +152,106 @@ type QueryOptions struct {
 	HasRange             bool
 	RangeMin             int64
 	RangeMax             int64
-	Count                string // exact, planned, estimated
-	JQ                   string // jq program from the jq= query parameter
-	JQArgs               string // raw JSON object from the jq_args= query parameter
+	Count                string   // exact, planned, estimated
+	estimateQuery        string   // data query without the GeoJSON, JSON and count wrappers, planned by count=planned and count=estimated
+	cursorLimit          int64    // page size of a keyset pagination, whose rows end with their __cursor
+	geoColumn            string   // geometry or geography column of the GeoJSON features
+	jsonRows             bool     // the rows are aggregated into a JSON array by the database (QueryWithJSON)
+	NextCursor           string   // cursor of the next page, returned in the Next-Cursor header
+	locationKeys         []string // primary key returned by a single-row insert, for the Location header
+	Location             string   // query string selecting the created row (id=eq.42), returned in the Location header
+	JQ                   string   // jq program from the jq= query parameter
+	JQArgs               string   // raw JSON object from the jq_args= query parameter
+	Plan                 string   // json, text: return the execution plan of the query instead of its result
+	PlanOptions          []string // EXPLAIN options from the plan media type: analyze, verbose, settings, buffers, wal
+	HandlingStrict       bool     // handling=strict: invalid or conflicting preferences are an error
+	HasMaxAffected       bool     // max-affected=N, honored with handling=strict
+	MaxAffected          int64    // maximum number of rows changed by an UPDATE or DELETE
+	AllowUnfiltered      bool     // unfiltered=allow: UPDATE and DELETE without filters, when disabled in the configuration
+	PreferenceApplied    []string // preferences honored, returned in the Preference-Applied header
+	preferenceError      error    // invalid preferences with handling=strict, returned by the executors
+	// valid preferences by name, to list the applied ones again for a function
+	preferences map[string]string
+}
+
+// appliedPreferences lists the preferences honored for the request, in the
+// Preference-Applied format, leaving out the ones that don't affect its method
+// (return= in a read, for example). When a preference has been given more than
+// once, it reports the value that takes effect.
+func appliedPreferences(options *QueryOptions, seen map[string]string, method string, rpc bool) []string {
+	write := method == "POST" || method == "PATCH" || method == "PUT" || method == "DELETE"
+	insert := !rpc && (method == "POST" || method == "PUT")
+	guarded := !rpc && (method == "PATCH" || method == "DELETE")
+	var applied []string
+	if write {
+		if options.ReturnRepresentation {
+			applied = append(applied, "return=representation")
+		} else if options.ReturnHeadersOnly {
+			applied = append(applied, "return=headers-only")
+		} else if _, ok := seen["return"]; ok {
+			applied = append(applied, "return=minimal")
+		}
+	}
+	if insert {
+		if options.IgnoreDuplicates {
+			applied = append(applied, "resolution=ignore-duplicates")
+		} else if options.MergeDuplicates {
+			applied = append(applied, "resolution=merge-duplicates")
+		}
+		if options.MissingDefault {
+			applied = append(applied, "missing=default")
+		} else if _, ok := seen["missing"]; ok {
+			applied = append(applied, "missing=null")
+		}
+	}
+	if rpc && method == "POST" && options.ParamsAsSingleObject {
+		applied = append(applied, "params=single-object")
+	}
+	if options.TxRollback {
+		applied = append(applied, "tx=rollback")
+	} else if options.TxCommit {
+		applied = append(applied, "tx=commit")
+	}
+	if method != "OPTIONS" && options.Count != "" {
+		applied = append(applied, "count="+options.Count)
+	}
+	if guarded && options.AllowUnfiltered {
+		applied = append(applied, "unfiltered=allow")
+	}
+	if guarded && options.HasMaxAffected {
+		applied = append(applied, "max-affected="+strconv.FormatInt(options.MaxAffected, 10))
+	}
+	if options.HandlingStrict {
+		applied = append(applied, "handling=strict")
+	} else if _, ok := seen["handling"]; ok {
+		applied = append(applied, "handling=lenient")
+	}
+	return applied
+}
+
+// functionPreferences lists again the preferences applied to a request that
+// calls a function, with a POST or, when readonly, a GET
+func (options *QueryOptions) functionPreferences(readonly bool) {
+	method := "POST"
+	if readonly {
+		method = "GET"
+	}
+	options.PreferenceApplied = appliedPreferences(options, options.preferences, method, true)
+}
+
+// dropPreference removes a preference that turned out not to be applicable
+// from the applied ones, or returns an error with handling=strict
+func (options *QueryOptions) dropPreference(preference, reason string) error {
+	if options.HandlingStrict {
+		return &ParseError{"invalid preference " + preference + ": " + reason}
+	}
+	options.PreferenceApplied = lo.Without(options.PreferenceApplied, preference)
+	return nil
 }
 
 // RequestParser is the interface used to parse the query string in the request and
 // extract the significant headers.
-// Initially we will support PostgREST mode and later perhaps others (Django?).
+// PostgREST mode is the default, Django mode is the alternative (see DjangoParser).
 type RequestParser interface {
 	parse(mainTable string, filters Filters) (*QueryParts, error)
 	getQueryOptions(req *Request) QueryOptions
@@ -130,10 +259,12 @@ type RequestParser interface {
 }
 
 type PostgRestParser struct {
-	tokens []string
-	quoted []bool // for each token, whether it was quoted in the source
-	cur    int
-	depth  int // current boolean-filter recursion depth (see maxFilterDepth)
+	tokens  []string
+	quoted  []bool // for each token, whether it was quoted in the source
+	cur     int
+	depth   int  // current boolean-filter recursion depth (see maxFilterDepth)
+	having  bool // parsing the having tree, whose fields can have aggregates
+	similar bool // scanning a select or an order, where the text of similar() is taken as it is
 }
 
 // maxFilterDepth caps the nesting of boolean filters (and/or) so a crafted query
@@ -155,7 +286,7 @@ func (e ParseError) Error() string { return e.msg }
 
 var postgRestReservedWords = map[string]struct{}{
 	"select": {}, "column": {}, "order": {}, "limit": {}, "offset": {}, "not": {}, "and": {}, "or": {}, "on_conlict": {},
-	"jq": {}, "jq_args": {},
+	"jq": {}, "jq_args": {}, "having": {}, "distinct": {},
 }
 
 // From https://github.com/PostgREST/postgrest/blob/main/src/PostgREST/Query/SqlFragment.hs
@@ -185,6 +316,16 @@ var postgRestParserOperators = map[string]string{
 	"plfts":   "@@",
 	"phfts":   "@@",
 	"wfts":    "@@",
+	"l2":      "<->", // pgvector distances, followed by a comparison:
+	"cosine":  "<=>", // embedding=cosine([1,2]).lt.0.5
+	"ip":      "<#>",
+	"intersects": "ST_Intersects", // PostGIS filters, see spatialCondition
+	"dwithin":    "ST_DWithin",
+	"bbox":       "&&",
+	"jpexists":   "@?", // SQL/JSON path, see jsonPathValue
+	"jpmatch":    "@@",
+	"sim":        "%",  // pg_trgm similarity
+	"wsim":       "%>", // pg_trgm word similarity, commutator of <%: name=wsim.bob is 'bob' <% name
 	"not":     "",  // just to be recognizable in filterParameters
 	"start":   "",  // recursive: base case seed (includes root)
 	"after":   "",  // recursive: base case seed (excludes root)
@@ -192,16 +333,80 @@ var postgRestParserOperators = map[string]string{
 	"via":     "",  // recursive: edge table for multi-table traversal
 }
 
-// isValidAggregateFunction checks if the given function name is a valid PostgREST aggregate function
+// pgvectorDistances maps the pgvector distances to their operators.
+// ip is the negative inner product, so that smaller is always closer.
+var pgvectorDistances = map[string]string{
+	"l2":     "<->",
+	"cosine": "<=>",
+	"ip":     "<#>",
+}
+
+// jsonPathOperators are the SQL/JSON path operators, whose value is the path:
+// doc=jpexists.$.items[*] ? (@.qty > 5)
+var jsonPathOperators = map[string]struct{}{
+	"jpexists": {},
+	"jpmatch":  {},
+}
+
+// textSearchFunctions are the functions on a full-text search query, used like
+// body.rank(wfts(english).cat dog)
+var textSearchFunctions = map[string]string{
+	"rank":     "ts_rank",
+	"rank_cd":  "ts_rank_cd",
+	"headline": "ts_headline",
+}
+
+// isValidAggregateFunction checks if the given function name is a valid aggregate function:
+// the PostgREST ones and the SmoothDB extensions, from array_agg on
 func isValidAggregateFunction(fn string) bool {
 	switch strings.ToLower(fn) {
-	case "avg", "count", "max", "min", "sum":
+	case "avg", "count", "max", "min", "sum",
+		"array_agg", "string_agg", "bool_and", "bool_or", "stddev", "stddev_pop", "stddev_samp",
+		"percentile_cont", "percentile_disc":
 		return true
 	default:
 		return false
 	}
 }
 
+// aggregateArgument parses the parentheses of an aggregate function, with the argument
+// of string_agg (the delimiter) and of the percentiles (the fraction), and validates it.
+// The fraction is rebuilt from its number, since it is embedded in the query.
+func (p *PostgRestParser) aggregateArgument(fn string) (string, error) {
+	var arg string
+	switch p.next() {
+	case "()":
+	case "(":
+		// a quoted ")" is part of the argument
+		for token, quoted := p.nextQuoted(); token != ")" || quoted; token, quoted = p.nextQuoted() {
+			if token == "" && !quoted {
+				return "", &ParseError{"')' expected"}
+			}
+			arg += token
+		}
+	default:
+		return "", &ParseError{"'(' expected"}
+	}
+	fn = strings.ToLower(fn)
+	switch fn {
+	case "string_agg":
+		if arg == "" {
+			return "", &ParseError{"string_agg requires a delimiter: string_agg(',')"}
+		}
+	case "percentile_cont", "percentile_disc":
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil || !(f >= 0 && f <= 1) {
+			return "", &ParseError{fn + " requires a fraction between 0 and 1: " + fn + "(0.5)"}
+		}
+		arg = strconv.FormatFloat(f, 'g', -1, 64)
+	default:
+		if arg != "" {
+			return "", &ParseError{fn + " has no arguments"}
+		}
+	}
+	return arg, nil
+}
+
 // castTypeRe matches a single-word type name, optionally schema-qualified and
 // with one or more trailing array markers (e.g. int4, public.mytype, text[][]).
 // It deliberately excludes quotes, spaces, commas and parentheses so a cast
@@ -256,8 +461,18 @@ func (p PostgRestParser) filterParameters(filters Filters) Filters {
 		if _, exists := postgRestReservedWords[k]; !exists {
 			var toRemove []int
 			for i, v := range vv {
-				prefix := strings.SplitN(v, ".", 2)[0]
-				if _, exists := postgRestParserOperators[prefix]; !exists {
+				// the operator ends at its arguments or at its value: cosine([1,2]).lt.0.5
+				prefix := v
+				end := strings.IndexAny(v, ".(")
+				if end != -1 {
+					prefix = v[:end]
+				}
+				_, exists := postgRestParserOperators[prefix]
+				if _, distance := pgvectorDistances[prefix]; distance && (end == -1 || v[end] != '(') {
+					// a distance needs its vector: host=ip.example.org is an argument
+					exists = false
+				}
+				if !exists {
 					skipped[k] = append(skipped[k], v)
 					toRemove = append(toRemove, i)
 				}
@@ -332,12 +547,21 @@ outer:
 				quoted = nil
 				wasSep = false
 			} else if strings.Contains(sep, string(cur)) {
+				similar := cur == '(' && p.similar && string(normal) == "similar" &&
+					len(p.tokens) != 0 && p.tokens[len(p.tokens)-1] == "."
 				if len(normal) != 0 {
 					p.addToken(string(normal), false)
 					normal = nil
 				}
 				p.addToken(string(cur), false)
 				wasSep = true
+				if similar {
+					// the text of similar is free: spaces and quotes are kept
+					// up to the matching ')'
+					end := closingParen(s, i+1)
+					p.addToken(s[i+1:end], true)
+					i = end - 1
+				}
 			} else {
 				normal = append(normal, cur)
 				wasSep = false
@@ -366,6 +590,24 @@ outer:
 	}
 }
 
+// closingParen returns the index of the ')' matching an already open parenthesis,
+// scanning s from start, or len(s) if there is none
+func closingParen(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(s)
+}
+
 // next returns the next token and advances the cursor.
 // It returns an empty string if it is at the end.
 func (p *PostgRestParser) next() string {
@@ -411,6 +653,7 @@ func (p *PostgRestParser) reset() {
 	p.quoted = nil
 	p.cur = 0
 	p.depth = 0
+	p.similar = false
 }
 
 // General grammar:
@@ -424,15 +667,17 @@ func (p *PostgRestParser) reset() {
 // Select := SelectList
 // SelectList := SelectItem (',' SelectItem)*
 // SelectItem := SelectField | SelectTable '(' SelectList ')'
-// SelectField := [<label> ':'] Field ['.' <aggregate> '()'] ["::" <cast>]
+// SelectField := [<label> ':'] Field ['.' <aggregate> '()' | '.' Distance] ["::" <cast>]
 // SelectTable := [<label> ':'] Field
 //
 // Order := OrderItem (',' OrderItem)*
-// OrderItem := Field ['.' ("asc" | "desc")] ['.' ("nullsfirst" | "nullslast")]
+// OrderItem := Field ['.' Distance] ['.' ("asc" | "desc")] ['.' ("nullsfirst" | "nullslast")]
+// Distance := ("l2" | "cosine" | "ip") '(' <vector> ')'
+
 //
 // Cond := CondName | CondBool
 // CondName := Field '.' OpValue
-// OpValue :=  ["not" ‘.’] <op> ‘.’ Values
+// OpValue :=  ["not" ‘.’] [Distance '.'] <op> ‘.’ Values
 // CondBool := BoolOp CondList
 // BoolOp := ["not" '.'] ("and" | "or")
 // CondList := ’(‘ Cond (‘,’ Cond)+ ‘)’
@@ -486,6 +731,7 @@ func (p *PostgRestParser) field(mayHaveTable bool, mayBeEmpty bool) (f Field, er
 
 // SELECT
 func (p *PostgRestParser) parseSelect(s string) ([]SelectField, error) {
+	p.similar = true
 	p.scan(s, ".,():!", "->>", "->", "::", "...", "()")
 	return p.selectList(nil)
 }
@@ -507,10 +753,11 @@ func (p *PostgRestParser) selectList(rel *SelectRelation) (selectFields []Select
 }
 
 func (p *PostgRestParser) selectItem(rel *SelectRelation) (selectFields []SelectField, err error) {
-	var label, cast, aggCast, fk, aggregate string
+	var label, cast, aggCast, fk, aggregate, aggArg, distance, vector, similar string
 	var spread, inner bool
 	var explicitLabel bool
 	var field Field
+	var textSearch *TextSearch
 	token := p.next()
 	if token == "" {
 		return nil, nil
@@ -563,21 +810,64 @@ func (p *PostgRestParser) selectItem(rel *SelectRelation) (selectFields []Select
 		if p.lookAhead() == "." {
 			next := p.cur + 1
 			if next < len(p.tokens) {
-				// Check if we have: . <function> ()
+				// Check if we have: . <function> () or . <function> ( <argument> )
 				aggFunc := p.tokens[next]
-				if isValidAggregateFunction(aggFunc) && next+1 < len(p.tokens) && p.tokens[next+1] == "()" {
+				if isValidAggregateFunction(aggFunc) && next+1 < len(p.tokens) &&
+					(p.tokens[next+1] == "()" || p.tokens[next+1] == "(") {
 					// Check if aggregates are enabled
 					if err := checkAggregatesEnabled(); err != nil {
 						return nil, err
 					}
 					p.next() // consume the dot
 					p.next() // consume the aggregate function
-					p.next() // consume the ()
+					aggArg, err = p.aggregateArgument(aggFunc)
+					if err != nil {
+						return nil, err
+					}
 					aggregate = strings.ToUpper(aggFunc)
 					// Set default label to aggregate function name if no explicit label was provided
 					if !explicitLabel {
 						label = strings.ToLower(aggFunc)
 					}
+				} else if op, ok := pgvectorDistances[aggFunc]; ok && next+1 < len(p.tokens) && p.tokens[next+1] == "(" {
+					// pgvector distance: embedding.cosine([1,2])
+					p.next() // consume the dot
+					p.next() // consume the distance
+					vector, err = p.vectorArgument()
+					if err != nil {
+						return nil, err
+					}
+					distance = op
+					if !explicitLabel {
+						label = aggFunc
+					}
+				} else if aggFunc == "similar" && next+1 < len(p.tokens) && p.tokens[next+1] == "(" {
+					// pg_trgm similarity: name.similar(bob)
+					p.next() // consume the dot
+					p.next() // consume similar
+					similar, err = p.similarArgument()
+					if err != nil {
+						return nil, err
+					}
+					if !explicitLabel {
+						// named after the field, so that more similarities can be selected
+						label = field.last
+						if label == "" {
+							label = field.name
+						}
+						label += "_similarity"
+					}
+				} else if fn, ok := textSearchFunctions[aggFunc]; ok && next+1 < len(p.tokens) && p.tokens[next+1] == "(" {
+					// full-text search: body.headline(wfts(english).cat dog)
+					p.next() // consume the dot
+					p.next() // consume the function
+					textSearch, err = p.textSearchArgument(fn)
+					if err != nil {
+						return nil, err
+					}
+					if !explicitLabel {
+						label = aggFunc
+					}
 				}
 			}
 		}
@@ -623,7 +913,7 @@ func (p *PostgRestParser) selectItem(rel *SelectRelation) (selectFields []Select
 			field.tablename = rel.name
 		}
 		if field.name != "," {
-			selectFields = append(selectFields, SelectField{field, label, cast, aggregate, aggCast, nil})
+			selectFields = append(selectFields, SelectField{field, label, cast, aggregate, aggArg, aggCast, nil, distance, vector, textSearch, similar})
 		} else {
 			p.back()
 		}
@@ -644,6 +934,9 @@ func (p *PostgRestParser) selectItem(rel *SelectRelation) (selectFields []Select
 		if cast != "" {
 			return nil, &ParseError{"table cannot have cast"}
 		}
+		if distance != "" {
+			return nil, &ParseError{"table cannot have distance"}
+		}
 		if aggregate != "" {
 			return nil, &ParseError{"table cannot have aggregate function"}
 		}
@@ -651,7 +944,7 @@ func (p *PostgRestParser) selectItem(rel *SelectRelation) (selectFields []Select
 		if rel != nil {
 			parent = rel.name
 		}
-		newrel := &SelectRelation{field.name, parent, spread, inner, fk, nil}
+		newrel := &SelectRelation{name: field.name, parent: parent, spread: spread, inner: inner, fk: fk}
 
 		if !isEmptyParens && p.lookAhead() != ")" {
 			newrel.fields, err = p.selectList(newrel)
@@ -706,10 +999,57 @@ func (p *PostgRestParser) parseConflicts(s string) ([]string, error) {
 	return conflictFields, nil
 }
 
+// DISTINCT
+func (p *PostgRestParser) parseDistinct(table, s string) ([]OrderField, error) {
+	var fields []OrderField
+	p.reset()
+	p.scan(s, ".,", "->>", "->")
+	for {
+		field, err := p.field(false, false)
+		if err != nil {
+			return nil, err
+		}
+		field.tablename = table
+		fields = append(fields, OrderField{field: field})
+		token := p.next()
+		if token == "" {
+			break
+		}
+		if token != "," {
+			return nil, &ParseError{"',' expected after distinct field '" + field.name + "'"}
+		}
+	}
+	return fields, nil
+}
+
+// distinctOrder puts the DISTINCT ON fields at the head of the order, as
+// PostgreSQL requires, keeping the direction of those already ordered
+func distinctOrder(table string, distinctFields, orderFields []OrderField) []OrderField {
+	sameField := func(d, o OrderField) bool {
+		return o.field.tablename == table && o.relation == "" && o.distance == "" &&
+			o.field.name == d.field.name && o.field.jsonPath == d.field.jsonPath
+	}
+	var order []OrderField
+	for _, d := range distinctFields {
+		if i := slices.IndexFunc(orderFields, func(o OrderField) bool { return sameField(d, o) }); i != -1 {
+			order = append(order, orderFields[i])
+		} else {
+			order = append(order, d)
+		}
+	}
+	for _, o := range orderFields {
+		if !slices.ContainsFunc(distinctFields, func(d OrderField) bool { return sameField(d, o) }) {
+			order = append(order, o)
+		}
+	}
+	return order
+}
+
 // ORDER
 func (p *PostgRestParser) parseOrderCondition(table, o string) (fields []OrderField, err error) {
 	var value1, value2 string
 	p.reset()
+	p.similar = true
 	p.scan(o, ".,()", "->>", "->")
 
 	token := p.lookAhead()
@@ -737,6 +1077,40 @@ func (p *PostgRestParser) parseOrderCondition(table, o string) (fields []OrderFi
 			p.next() // consume )
 		}
 
+		// pgvector distance: embedding.cosine([1,2])
+		var distance, vector, similar string
+		var textSearch *TextSearch
+		if p.lookAhead() == "." && p.cur+2 < len(p.tokens) && p.tokens[p.cur+2] == "(" {
+			if op, ok := pgvectorDistances[p.tokens[p.cur+1]]; ok {
+				p.next() // consume .
+				p.next() // consume the distance
+				vector, err = p.vectorArgument()
+				if err != nil {
+					return nil, err
+				}
+				distance = op
+			} else if p.tokens[p.cur+1] == "similar" {
+				// pg_trgm distance: name.similar(bob)
+				p.next() // consume .
+				p.next() // consume similar
+				similar, err = p.similarArgument()
+				if err != nil {
+					return nil, err
+				}
+			} else if fn, ok := textSearchFunctions[p.tokens[p.cur+1]]; ok {
+				// full-text search rank: body.rank(wfts(english).cat dog)
+				if fn == "ts_headline" {
+					return nil, &ParseError{"headline cannot be used in order"}
+				}
+				p.next() // consume .
+				p.next() // consume the function
+				textSearch, err = p.textSearchArgument(fn)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		field.tablename = table
 		value1 = ""
 		value2 = ""
@@ -767,7 +1141,8 @@ func (p *PostgRestParser) parseOrderCondition(table, o string) (fields []OrderFi
 			invertNulls = true
 		}
 		fields = append(fields,
-			OrderField{field: field, relation: relation, descending: descending, invertNulls: invertNulls})
+			OrderField{field: field, relation: relation, descending: descending, invertNulls: invertNulls,
+				distance: distance, vector: vector, textSearch: textSearch, similar: similar})
 		if p.lookAhead() != "," {
 			break
 		}
@@ -814,6 +1189,146 @@ func (p *PostgRestParser) parseWhereCondition(mainTable, key, value string, root
 	return p.cond(mainTable, root)
 }
 
+// checkSpatialFilter validates the arguments and the values of a spatial filter,
+// splitting the bounding box of bbox into its coordinates
+func checkSpatialFilter(node *WhereConditionNode) error {
+	if node.opModifier != "" || len(node.values) != 1 {
+		return &ParseError{node.opSource + " requires a single value"}
+	}
+	switch node.opSource {
+	case "intersects":
+		if len(node.opArgs) != 0 {
+			return &ParseError{"intersects has no arguments"}
+		}
+	case "dwithin":
+		if len(node.opArgs) != 1 {
+			return &ParseError{"dwithin requires a distance: dwithin(1000)"}
+		}
+		if _, err := strconv.ParseFloat(node.opArgs[0], 64); err != nil {
+			return &ParseError{"invalid distance: " + node.opArgs[0]}
+		}
+	case "bbox":
+		if len(node.opArgs) > 1 {
+			return &ParseError{"bbox accepts only the SRID: bbox(4326)"}
+		}
+		if len(node.opArgs) == 1 {
+			if _, err := strconv.Atoi(node.opArgs[0]); err != nil {
+				return &ParseError{"invalid SRID: " + node.opArgs[0]}
+			}
+		}
+		value := node.values[0]
+		if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+			return &ParseError{"bbox requires (xmin,ymin,xmax,ymax)"}
+		}
+		coords := strings.Split(value[1:len(value)-1], ",")
+		if len(coords) != 4 {
+			return &ParseError{"bbox requires (xmin,ymin,xmax,ymax)"}
+		}
+		for _, c := range coords {
+			if _, err := strconv.ParseFloat(c, 64); err != nil {
+				return &ParseError{"invalid coordinate: " + c}
+			}
+		}
+		node.values = coords
+	}
+	return nil
+}
+
+// vectorArgument parses the parenthesized vector of a pgvector distance, like ([1,2.5]),
+// and returns it in the pgvector text format.
+// The vector is validated and rebuilt from its numbers, since orders embed it in the query.
+func (p *PostgRestParser) vectorArgument() (string, error) {
+	if p.next() != "(" {
+		return "", &ParseError{"'(' expected"}
+	}
+	var s string
+	for token := p.next(); token != ")"; token = p.next() {
+		if token == "" {
+			return "", &ParseError{"')' expected"}
+		}
+		s += token
+	}
+	var numbers []float64
+	if err := json.Unmarshal([]byte(s), &numbers); err != nil || len(numbers) == 0 {
+		return "", &ParseError{"invalid vector: " + s}
+	}
+	vector := "["
+	for i, n := range numbers {
+		if i != 0 {
+			vector += ","
+		}
+		vector += strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	return vector + "]", nil
+}
+
+// similarArgument parses the text compared by similar, taken as it is
+// by the scanner: (bob smith), (o'brien, j)
+func (p *PostgRestParser) similarArgument() (string, error) {
+	if p.next() != "(" {
+		return "", &ParseError{"'(' expected"}
+	}
+	s := p.next()
+	if p.next() != ")" {
+		return "", &ParseError{"')' expected"}
+	}
+	if s == "" {
+		return "", &ParseError{"text expected in similar"}
+	}
+	return s, nil
+}
+
+// textSearchArgument parses the full-text search query of fn, written like the
+// value of a FTS filter: (wfts(english).cat dog). The query ends at the matching ')'.
+func (p *PostgRestParser) textSearchArgument(fn string) (*TextSearch, error) {
+	if p.next() != "(" {
+		return nil, &ParseError{"'(' expected"}
+	}
+	ts := &TextSearch{function: fn, operator: p.next()}
+	switch ts.operator {
+	case "fts", "plfts", "phfts", "wfts":
+	default:
+		return nil, &ParseError{"fts, plfts, phfts or wfts expected"}
+	}
+	if p.lookAhead() == "(" {
+		p.next()
+		ts.language = p.next()
+		if ts.language == "" || p.next() != ")" {
+			return nil, &ParseError{"')' expected"}
+		}
+	}
+	if p.next() != "." {
+		return nil, &ParseError{"'.' expected"}
+	}
+	depth := 0
+	for {
+		token, quoted := p.nextQuoted()
+		if quoted {
+			// a phrase for websearch_to_tsquery: wfts."cat dog" -mouse, with
+			// the space skipped by the scanner
+			ts.query += `"` + token + `" `
+			continue
+		}
+		if token == "" {
+			return nil, &ParseError{"')' expected"}
+		}
+		if token == "(" {
+			depth++
+		} else if token == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		ts.query += token
+	}
+	ts.query = strings.TrimSpace(ts.query)
+	if ts.query == "" {
+		return nil, &ParseError{"text search query expected"}
+	}
+	return ts, nil
+}
+
 func (p *PostgRestParser) completeIfFloat() string {
 	// @@ should test if the current token is a number
 	if p.lookAhead() == "." {
@@ -838,6 +1353,47 @@ func quoteJsonString(s string) string {
 	return b.String()
 }
 
+// jsonPathValue parses the value of the SQL/JSON path operators, up to the end
+// of the condition. The separators are kept, and the quoted strings are written
+// back as JSON strings: $.items[*] ? (@.name == "a,b")
+func (p *PostgRestParser) jsonPathValue() (string, error) {
+	var path string
+	depth := 0
+	for {
+		token, quoted := p.nextQuoted()
+		if quoted {
+			// with the space skipped by the scanner
+			path += quoteJsonString(token) + " "
+			continue
+		}
+		if token == "" {
+			break
+		}
+		if token == "(" || token == "[" {
+			depth++
+		} else if token == ")" || token == "]" {
+			if depth == 0 {
+				// the end of a logic operator
+				p.back()
+				break
+			}
+			depth--
+		} else if token == "," && depth == 0 {
+			p.back()
+			break
+		}
+		path += token
+	}
+	path = strings.TrimSpace(path)
+	if depth != 0 {
+		return "", &ParseError{"')' or ']' expected in json path"}
+	}
+	if path == "" {
+		return "", &ParseError{"json path expected"}
+	}
+	return path, nil
+}
+
 // jsonPathIsJsonTyped returns true if the json path produces a json value,
 // ie its last extraction operator is -> and not ->>
 func jsonPathIsJsonTyped(path string) bool {
@@ -1009,6 +1565,14 @@ func (p *PostgRestParser) cond(mainTable string, parent *WhereConditionNode) (er
 		if err != nil {
 			return err
 		}
+		if p.having && strings.ToLower(node.field.name) == "count" && p.lookAhead() == "(" {
+			// standalone count(): COUNT(*)
+			if _, err = p.aggregateArgument("count"); err != nil {
+				return err
+			}
+			node.aggregate = "COUNT"
+			node.field.name = "*"
+		}
 		if node.field.tablename == "" {
 			if parent.field.tablename != "" {
 				node.field.tablename = parent.field.tablename
@@ -1021,6 +1585,18 @@ func (p *PostgRestParser) cond(mainTable string, parent *WhereConditionNode) (er
 			return &ParseError{"'=' expected"}
 		}
 		token = p.next()
+		if p.having && node.aggregate == "" && isValidAggregateFunction(token) && p.lookAhead() == "(" {
+			// aggregate of the field: amount.sum().gt.100
+			node.aggArg, err = p.aggregateArgument(token)
+			if err != nil {
+				return err
+			}
+			node.aggregate = strings.ToUpper(token)
+			if p.next() != "." {
+				return &ParseError{"'.' expected"}
+			}
+			token = p.next()
+		}
 		if token == "not" {
 			node.not = true
 			if p.next() != "." {
@@ -1032,14 +1608,35 @@ func (p *PostgRestParser) cond(mainTable string, parent *WhereConditionNode) (er
 		if !ok {
 			return &ParseError{"valid sql operator expected"}
 		}
+		if distance, ok := pgvectorDistances[token]; ok {
+			// pgvector distance compared with the value: cosine([1,2]).lt.0.5
+			node.vector, err = p.vectorArgument()
+			if err != nil {
+				return err
+			}
+			node.distance = distance
+			if p.next() != "." {
+				return &ParseError{"'.' expected"}
+			}
+			token = p.next()
+			switch token {
+			case "lt", "lte", "gt", "gte":
+				op = postgRestParserOperators[token]
+			default:
+				return &ParseError{"lt, lte, gt or gte expected after a distance"}
+			}
+		}
 		node.operator = op
 		node.opSource = token
 		token = p.next()
 		if token == "(" {
-			if op == "@@" {
-				// FTS operator arguments: fts(english)
+			if _, ok := jsonPathOperators[node.opSource]; ok {
+				return &ParseError{"'.' expected after " + node.opSource}
+			}
+			if op == "@@" || node.opSource == "dwithin" || node.opSource == "bbox" {
+				// FTS operator arguments: fts(english), spatial ones: dwithin(1000)
 				for {
-					node.opArgs = append(node.opArgs, p.next())
+					node.opArgs = append(node.opArgs, p.next()+p.completeIfFloat())
 					token = p.next()
 					if token != "," {
 						break
@@ -1063,7 +1660,13 @@ func (p *PostgRestParser) cond(mainTable string, parent *WhereConditionNode) (er
 			}
 		}
 		if token == "." { // value
-			if node.operator == "IN" {
+			if _, ok := jsonPathOperators[node.opSource]; ok {
+				path, err := p.jsonPathValue()
+				if err != nil {
+					return err
+				}
+				node.values = append(node.values, path)
+			} else if node.operator == "IN" {
 				if p.next() != "(" {
 					return &ParseError{"'(' expected"}
 				}
@@ -1105,11 +1708,52 @@ func (p *PostgRestParser) cond(mainTable string, parent *WhereConditionNode) (er
 				}
 			}
 		}
+		if node.distance != "" && len(node.values) != 1 {
+			return &ParseError{"a value expected after " + node.opSource}
+		}
+		if _, ok := jsonPathOperators[node.opSource]; ok && len(node.values) == 0 {
+			return &ParseError{"json path expected after " + node.opSource}
+		}
+		if _, ok := spatialOperators[node.opSource]; ok {
+			if err = checkSpatialFilter(node); err != nil {
+				return err
+			}
+		}
 	}
 	parent.children = append(parent.children, node)
 	return nil
 }
 
+// checkHaving verifies that every having condition is on an aggregate or on a
+// column grouped by the aggregates of the select list
+func checkHaving(node *WhereConditionNode, selectFields []SelectField) error {
+	var hasAggregates bool
+	grouped := map[string]bool{}
+	for _, sfield := range selectFields {
+		if sfield.aggregate != "" {
+			hasAggregates = true
+		} else if sfield.relation == nil {
+			grouped[sfield.field.name+sfield.field.jsonPath] = true
+		}
+	}
+	if !hasAggregates {
+		return &ParseError{"having requires an aggregate in select"}
+	}
+	var check func(node *WhereConditionNode) error
+	check = func(node *WhereConditionNode) error {
+		if node.field.name != "" && node.aggregate == "" && !grouped[node.field.name+node.field.jsonPath] {
+			return &ParseError{"having condition on " + node.field.name + " is neither an aggregate nor a grouped column"}
+		}
+		for _, child := range node.children {
+			if err := check(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(node)
+}
+
 // QUERY
 func (p PostgRestParser) parse(mainTable string, filters Filters) (parts *QueryParts, err error) {
 	parts = &QueryParts{}
@@ -1178,14 +1822,47 @@ func (p PostgRestParser) parse(mainTable string, filters Filters) (parts *QueryP
 	delete(filters, "jq")
 	delete(filters, "jq_args")
 
+	// HAVING
+	// having=(amount.sum().gt.100,count().gte.2), having=or(...)
+	if havingFilters, ok := filters["having"]; ok {
+		if err := checkAggregatesEnabled(); err != nil {
+			return nil, err
+		}
+		parts.havingConditionsTree = &WhereConditionNode{}
+		p.having = true
+		for _, h := range havingFilters {
+			key, value := "and", h
+			if before, after, found := strings.Cut(h, "("); found && isBooleanOp(before) {
+				key, value = before, "("+after
+			} else if !strings.HasPrefix(h, "(") {
+				// a single condition
+				value = "(" + h + ")"
+			}
+			if err = p.parseWhereCondition(mainTable, key, value, parts.havingConditionsTree); err != nil {
+				return nil, err
+			}
+		}
+		p.having = false
+		delete(filters, "having")
+		if err = checkHaving(parts.havingConditionsTree, parts.selectFields); err != nil {
+			return nil, err
+		}
+	}
+
 	// ORDER
 	// order=f1,f2.asc,f3.desc.nullslast
 	for k, v := range filters {
 		var order, table string
 		if k == "order" {
 			table = mainTable
-		} else if strings.HasSuffix(k, ".order") {
-			table = strings.TrimSuffix(k, ".order")
+		} else if path, ok := strings.CutSuffix(k, ".order"); ok {
+			// rel.order or rel1.rel2.order: the fields belong to the last table in the path,
+			// that can be named by its label
+			rel := findSelectRelation(parts.selectFields, strings.Split(path, "."))
+			if rel == nil {
+				return nil, &ParseError{"'" + path + "' is not an embedded resource in this request"}
+			}
+			table = rel.name
 		} else {
 			continue
 		}
@@ -1203,6 +1880,20 @@ func (p PostgRestParser) parse(mainTable string, filters Filters) (parts *QueryP
 		delete(filters, k)
 	}
 
+	// DISTINCT
+	// distinct, distinct=f1,f2 for DISTINCT ON
+	if distinctFilter, ok := filters["distinct"]; ok {
+		parts.distinct = true
+		if distinctFilter[0] != "" {
+			parts.distinctFields, err = p.parseDistinct(mainTable, distinctFilter[0])
+			if err != nil {
+				return nil, err
+			}
+			parts.orderFields = distinctOrder(mainTable, parts.distinctFields, parts.orderFields)
+		}
+		delete(filters, "distinct")
+	}
+
 	// LIMIT
 	// limit=100
 	if limitFilter, ok := filters["limit"]; ok {
@@ -1221,6 +1912,40 @@ func (p PostgRestParser) parse(mainTable string, filters Filters) (parts *QueryP
 		parts.offset = offsetFilter[0]
 		delete(filters, "offset")
 	}
+	// AFTER
+	// after=<cursor> from the Next-Cursor header, after= for the first page.
+	// after is not reserved: a value with an operator, like after=gt.5, filters
+	// a column named after, while cursors never contain a dot.
+	if afterFilter, ok := filters["after"]; ok && !strings.Contains(afterFilter[0], ".") {
+		parts.keyset = true
+		parts.after = afterFilter[0]
+		if len(afterFilter) == 1 {
+			delete(filters, "after")
+		} else {
+			filters["after"] = afterFilter[1:]
+		}
+	}
+	// EMBEDDED LIMIT AND OFFSET
+	// rel.limit=2&rel.offset=1, rel1.rel2.limit=1
+	for k, v := range filters {
+		path, param, ok := cutLast(k, ".")
+		if !ok || (param != "limit" && param != "offset") {
+			continue
+		}
+		if !isNonNegativeInt(v[0]) {
+			return nil, &ParseError{param + " must be a non-negative integer"}
+		}
+		rel := findSelectRelation(parts.selectFields, strings.Split(path, "."))
+		if rel == nil {
+			return nil, &ParseError{"'" + path + "' is not an embedded resource in this request"}
+		}
+		if param == "limit" {
+			rel.limit = v[0]
+		} else {
+			rel.offset = v[0]
+		}
+		delete(filters, k)
+	}
 
 	// RECURSIVE
 	// id=start.5&manager_id=recurse.3
@@ -1406,17 +2131,111 @@ func (p PostgRestParser) parse(mainTable string, filters Filters) (parts *QueryP
 			}
 		}
 	}
+	if err = linkEmbedNullFilters(parts.selectFields, parts.whereConditionsTree); err != nil {
+		return nil, err
+	}
 	return parts, nil
 }
 
+// cutLast slices s around the last instance of sep, like strings.Cut does for the first one.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// findSelectRelation follows a path of relation names or labels (eg. clients.projects)
+// through the select fields and returns the last relation, or nil if it is not embedded.
+func findSelectRelation(fields []SelectField, path []string) *SelectRelation {
+	for _, sf := range fields {
+		if sf.relation == nil || (sf.label != path[0] && sf.relation.name != path[0]) {
+			continue
+		}
+		if len(path) == 1 {
+			return sf.relation
+		}
+		if rel := findSelectRelation(sf.relation.fields, path[1:]); rel != nil {
+			return rel
+		}
+	}
+	return nil
+}
+
+// linkEmbedNullFilters finds the filters that name an embedded resource instead of a column
+// (eg. clients=is.null, or=(clients.not.is.null,name.eq.x)) and links them to the resource,
+// so that they test the existence of the related rows.
+// An embedded resource is named by its label, if any, so that embedding by column
+// (client:client_id(*)) does not interfere with the filters on the column.
+func linkEmbedNullFilters(selectFields []SelectField, node *WhereConditionNode) error {
+	for _, n := range node.children {
+		if n.field.name == "" {
+			// boolean operator
+			if err := linkEmbedNullFilters(selectFields, n); err != nil {
+				return err
+			}
+			continue
+		}
+		if n.field.jsonPath != "" {
+			continue
+		}
+		fields := selectFields
+		if len(n.field.relPath) != 0 {
+			rel := findSelectRelation(selectFields, n.field.relPath)
+			if rel == nil {
+				continue
+			}
+			fields = rel.fields
+		}
+		for i, sf := range fields {
+			if sf.relation == nil {
+				continue
+			}
+			name := sf.label
+			if name == "" {
+				name = sf.relation.name
+			}
+			if name != n.field.name {
+				continue
+			}
+			if n.operator != "IS" || len(n.values) != 1 || (n.values[0] != "null" && n.values[0] != "not_null") {
+				return &ParseError{"only is.null or not.is.null filters are allowed on the embedded resource '" + name + "'"}
+			}
+			n.embed = &fields[i]
+			break
+		}
+	}
+	return nil
+}
+
 var rangeRe = regexp.MustCompile(`^(\d+)?-(\d+)?$`)
 
+// parsePlanOptions parses the options parameter of the plan media types,
+// like "analyze|verbose", and reports whether all of them are supported.
+func parsePlanOptions(value string) ([]string, bool) {
+	if value == "" {
+		return nil, true
+	}
+	var planOptions []string
+	for _, o := range strings.Split(value, "|") {
+		switch o {
+		case "analyze", "verbose", "settings", "buffers", "wal":
+			if !lo.Contains(planOptions, o) {
+				planOptions = append(planOptions, o)
+			}
+		default:
+			return nil, false
+		}
+	}
+	return planOptions, true
+}
+
 func (p PostgRestParser) getQueryOptions(req *Request) QueryOptions {
 	header := req.Header
 	options := QueryOptions{}
 
 	acceptHeaders := header.Values("Accept")
-	mediatype := contentNegotiation(acceptHeaders)
+	mediatype, params := contentNegotiation(acceptHeaders)
 
 	switch mediatype {
 	case "application/vnd.pgrst.object+json":
@@ -1424,25 +2243,62 @@ func (p PostgRestParser) getQueryOptions(req *Request) QueryOptions {
 		options.Singular = true
 	case "application/json",
 		"text/csv",
-		"application/octet-stream":
+		"application/octet-stream",
+		"application/geo+json":
 		options.ContentType = mediatype
+	case "application/vnd.pgrst.plan+json",
+		"application/vnd.pgrst.plan+text",
+		"application/vnd.pgrst.plan":
+		planOptions, ok := parsePlanOptions(params["options"])
+		if !ok {
+			options.ContentType = "unknown/unknown"
+			break
+		}
+		options.Plan = "text"
+		if strings.HasSuffix(mediatype, "+json") {
+			options.Plan = "json"
+		}
+		options.ContentType = "application/vnd.pgrst.plan+" + options.Plan
+		options.PlanOptions = planOptions
 	default:
 		options.ContentType = "unknown/unknown"
 	}
 
+	var invalid []string
+	seen := map[string]string{} // preference name -> value
 	preferValues := header.Values("Prefer")
 	for _, prefer := range preferValues {
 		parts := strings.Split(prefer, ",")
 		for _, part := range parts {
 			preferValue := strings.TrimSpace(part)
+			if preferValue == "" {
+				continue
+			}
+			// the same preference with two values is a conflict
+			name, value, _ := strings.Cut(preferValue, "=")
+			if prev, ok := seen[name]; ok && prev != value {
+				invalid = append(invalid, name+"="+prev+" and "+preferValue)
+			}
+			seen[name] = value
 
 			switch preferValue {
 			case "return=representation":
 				options.ReturnRepresentation = true
+				options.ReturnHeadersOnly = false
+			case "return=minimal":
+				options.ReturnRepresentation = false
+				options.ReturnHeadersOnly = false
+			case "return=headers-only":
+				options.ReturnRepresentation = false
+				options.ReturnHeadersOnly = true
 			case "resolution=merge-duplicates":
 				options.MergeDuplicates = true
 			case "resolution=ignore-duplicates":
 				options.IgnoreDuplicates = true
+			case "missing=default":
+				options.MissingDefault = true
+			case "missing=null":
+				options.MissingDefault = false
 			case "params=single-object":
 				options.ParamsAsSingleObject = true
 			case "tx=commit":
@@ -1451,9 +2307,37 @@ func (p PostgRestParser) getQueryOptions(req *Request) QueryOptions {
 				options.TxRollback = true
 			case "count=exact":
 				options.Count = "exact"
+			case "count=planned":
+				options.Count = "planned"
+			case "count=estimated":
+				options.Count = "estimated"
+			case "handling=strict":
+				options.HandlingStrict = true
+			case "handling=lenient":
+				options.HandlingStrict = false
+			case "unfiltered=allow":
+				options.AllowUnfiltered = true
+			default:
+				if n, err := strconv.ParseInt(value, 10, 64); name == "max-affected" && err == nil && n >= 0 {
+					options.HasMaxAffected = true
+					options.MaxAffected = n
+					continue
+				}
+				delete(seen, name)
+				invalid = append(invalid, preferValue)
 			}
 		}
 	}
+	// max-affected is a guard: it is not honored in lenient mode, where a
+	// preference can be silently ignored
+	options.HasMaxAffected = options.HasMaxAffected && options.HandlingStrict
+	// a request is taken on a table here: only the route knows that it calls a function
+	// (see functionPreferences)
+	options.preferences = seen
+	options.PreferenceApplied = appliedPreferences(&options, seen, req.Method, false)
+	if options.HandlingStrict && len(invalid) > 0 {
+		options.preferenceError = &ParseError{"invalid preferences: " + strings.Join(invalid, ", ")}
+	}
 
 	options.RangeMin = -1
 	options.RangeMax = -1
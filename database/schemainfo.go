@@ -36,6 +36,7 @@ type Relationship struct {
 type SchemaInfo struct {
 	cachedTypes             map[uint32]Type
 	cachedComposites        []Type
+	cachedVectors           []Type
 	cachedTables            map[string]Table
 	cachedColumnTypes       map[string]map[string]ColumnType
 	cachedPrimaryKeys       map[string]Constraint
@@ -44,6 +45,7 @@ type SchemaInfo struct {
 	cachedCheckConstraints  map[string][]Constraint
 	cachedRelationships     map[string][]Relationship
 	cachedFunctions         map[string]Function
+	cachedComputedFields    map[string]map[string]Function
 }
 
 func NewSchemaInfo(ctx context.Context, db *Database) (*SchemaInfo, error) {
@@ -57,6 +59,7 @@ func NewSchemaInfo(ctx context.Context, db *Database) (*SchemaInfo, error) {
 	dbi.cachedCheckConstraints = map[string][]Constraint{}
 	dbi.cachedRelationships = map[string][]Relationship{}
 	dbi.cachedFunctions = map[string]Function{}
+	dbi.cachedComputedFields = map[string]map[string]Function{}
 
 	// Types
 	types, err := GetTypes(ctx)
@@ -68,6 +71,9 @@ func NewSchemaInfo(ctx context.Context, db *Database) (*SchemaInfo, error) {
 		if t.IsComposite {
 			dbi.cachedComposites = append(dbi.cachedComposites, t)
 		}
+		if t.IsVector() {
+			dbi.cachedVectors = append(dbi.cachedVectors, t)
+		}
 	}
 	// Tables
 	tables, err := GetTables(ctx)
@@ -184,12 +190,19 @@ func NewSchemaInfo(ctx context.Context, db *Database) (*SchemaInfo, error) {
 		if !ok || !argType.IsTable {
 			continue
 		}
+		sourceTable := _s(argType.Name, argType.Schema)
 		// The return type must be a table or composite type
 		retType, ok := dbi.cachedTypes[f.ReturnTypeId]
 		if !ok || (!retType.IsTable && !retType.IsComposite) {
+			// Computed fields: a scalar function of the row type is a virtual column
+			if !f.ReturnIsSet && !f.HasOut && f.Returns != "void" {
+				if dbi.cachedComputedFields[sourceTable] == nil {
+					dbi.cachedComputedFields[sourceTable] = map[string]Function{}
+				}
+				dbi.cachedComputedFields[sourceTable][_s(f.Name, f.Schema)] = f
+			}
 			continue
 		}
-		sourceTable := _s(argType.Name, argType.Schema)
 		relatedTable := _s(retType.Name, retType.Schema)
 		// ROWS 1 hint means the function effectively returns a single row (to-one)
 		returnIsSet := f.ReturnIsSet && f.ReturnRows != 1
@@ -601,6 +614,15 @@ func filterRelationships(rels []Relationship, relatedTable, fk string) []Relatio
 	})
 }
 
+// GetComputedField returns the function (schema.name) implementing a computed field of a table, if any
+func (si *SchemaInfo) GetComputedField(ftable, fname string) *Function {
+	f, ok := si.cachedComputedFields[ftable][fname]
+	if !ok {
+		return nil
+	}
+	return &f
+}
+
 func (si *SchemaInfo) GetFunction(name string) *Function {
 	f, ok := si.cachedFunctions[name]
 	if !ok {
@@ -428,33 +428,39 @@ func (t *TextBuilder) appendRange(buf []byte, typ uint32, info *SchemaInfo, at a
 	t.WriteByte('"')
 	rp := 0
 	rangeType := buf[rp]
+	rp += 1
+	if rangeType&emptyMask > 0 {
+		t.WriteString("empty\"")
+		return nil
+	}
+	// unbounded limits have no value in the buffer
 	switch {
-	case rangeType&emptyMask > 0,
-		rangeType&lowerUnboundedMask > 0:
-		// nothing to do
+	case rangeType&lowerUnboundedMask > 0:
+		t.WriteByte('(')
 	case rangeType&lowerInclusiveMask > 0:
 		t.WriteByte('[')
 	default:
 		t.WriteByte('(')
 	}
-	rp += 1
-	valuLen1 := binary.BigEndian.Uint32(buf[rp:])
-	rp += 4
-	if err := at.appendType(buf[rp:], typ, info); err != nil {
-		return err
+	if rangeType&lowerUnboundedMask == 0 {
+		valuLen1 := binary.BigEndian.Uint32(buf[rp:])
+		rp += 4
+		if err := at.appendType(buf[rp:rp+int(valuLen1)], typ, info); err != nil {
+			return err
+		}
+		rp += int(valuLen1)
 	}
-	rp += int(valuLen1)
 	t.WriteByte(',')
-	valuLen2 := binary.BigEndian.Uint32(buf[rp:])
-	rp += 4
-	if err := at.appendType(buf[rp:], typ, info); err != nil {
-		return err
+	if rangeType&upperUnboundedMask == 0 {
+		valuLen2 := binary.BigEndian.Uint32(buf[rp:])
+		rp += 4
+		if err := at.appendType(buf[rp:rp+int(valuLen2)], typ, info); err != nil {
+			return err
+		}
 	}
-	rp += int(valuLen2)
 	switch {
-	case rangeType&emptyMask > 0,
-		rangeType&upperUnboundedMask > 0:
-		// nothing to do
+	case rangeType&upperUnboundedMask > 0:
+		t.WriteByte(')')
 	case rangeType&upperInclusiveMask > 0:
 		t.WriteByte(']')
 	default:
@@ -464,6 +470,32 @@ func (t *TextBuilder) appendRange(buf []byte, typ uint32, info *SchemaInfo, at a
 	return nil
 }
 
+// appendMultirange writes a multirange as an array of ranges, ie ["[1,3)","[5,7)"].
+// typ is the range type of the multirange.
+func (t *TextBuilder) appendMultirange(buf []byte, typ uint32, info *SchemaInfo, at appendTyper) error {
+	rt := info.GetTypeById(typ)
+	if rt == nil || rt.RangeSubType == nil {
+		return &SerializeError{msg: "unknown range type of multirange"}
+	}
+	rp := uint32(0)
+	count := binary.BigEndian.Uint32(buf[rp:])
+	rp += 4
+	t.WriteByte('[')
+	for i := uint32(0); i < count; i++ {
+		if i > 0 {
+			t.WriteByte(',')
+		}
+		rangeLen := binary.BigEndian.Uint32(buf[rp:])
+		rp += 4
+		if err := t.appendRange(buf[rp:rp+rangeLen], *rt.RangeSubType, info, at); err != nil {
+			return err
+		}
+		rp += rangeLen
+	}
+	t.WriteByte(']')
+	return nil
+}
+
 func (t *TextBuilder) appendComposite(buf []byte, typ *Type, info *SchemaInfo, at appendTyper) error {
 	rp := uint32(0)
 	nfields := binary.BigEndian.Uint32(buf[rp:])
@@ -550,16 +582,24 @@ func (j *JSONSerializer) appendType(buf []byte, typ uint32, info *SchemaInfo) er
 		ct := info.GetTypeById(typ)
 		if ct != nil {
 			switch {
+			case ct.IsDomain:
+				// domains nested in arrays and composites carry their own oid
+				return j.appendType(buf, ct.DomainBaseType, info)
 			case ct.IsArray:
 				return j.appendArray(buf, ct.ArraySubType, info, j)
 			case ct.IsRange:
 				return j.appendRange(buf, *ct.RangeSubType, info, j)
+			case ct.IsMultirange:
+				return j.appendMultirange(buf, *ct.MultirangeSubType, info, j)
 			case ct.IsComposite:
 				return j.appendComposite(buf, ct, info, j)
 			case ct.IsEnum:
 				j.WriteByte('"')
 				j.appendEnum(buf)
 				j.WriteByte('"')
+			case ct.IsVector():
+				// pgvector text format is already a JSON array
+				j.Write(buf)
 			default:
 				// Unknown scalar types (e.g. ltree): serialize as text
 				j.WriteByte('"')
@@ -688,10 +728,15 @@ func (csv *CSVSerializer) appendType(buf []byte, typ uint32, info *SchemaInfo) e
 		ct := info.GetTypeById(typ)
 		if ct != nil {
 			switch {
+			case ct.IsDomain:
+				// domains nested in arrays and composites carry their own oid
+				return csv.appendType(buf, ct.DomainBaseType, info)
 			case ct.IsArray:
 				return csv.appendArray(buf, ct.ArraySubType, info, csv)
 			case ct.IsRange:
 				return csv.appendRange(buf, *ct.RangeSubType, info, csv)
+			case ct.IsMultirange:
+				return csv.appendMultirange(buf, *ct.MultirangeSubType, info, csv)
 			case ct.IsComposite:
 				return csv.appendComposite(buf, ct, info, csv)
 			case ct.IsEnum:
@@ -821,6 +866,50 @@ func (b *BinarySerializer) Serialize(rows pgx.Rows, scalar bool, single bool, in
 	return b.Bytes(), int64(b.Len()), nil
 }
 
+// GeoJSONSerializer writes a GeoJSON FeatureCollection with the features
+// built by PostGIS in the __feature column
+type GeoJSONSerializer struct {
+	TextBuilder
+}
+
+func (g *GeoJSONSerializer) Serialize(rows pgx.Rows, scalar bool, single bool, info *SchemaInfo) (out []byte, n int64, err error) {
+	defer serializeRecover(&out, &n, &err)
+	fds := rows.FieldDescriptions()
+	var count int64
+	var _count int64 = -1
+
+	g.WriteString(`{"type":"FeatureCollection","features":[`)
+	for rows.Next() {
+		bufRaw := rows.RawValues()
+		for i, fd := range fds {
+			switch fd.Name {
+			case "__count":
+				_count = toInt64(bufRaw[i])
+			case "__feature":
+				if bufRaw[i] == nil {
+					// the row carrying only the count
+					continue
+				}
+				if count > 0 {
+					g.WriteByte(',')
+				}
+				g.Write(bufRaw[i])
+				count++
+			}
+		}
+	}
+	g.WriteString("]}")
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if _count != -1 {
+		// we have a count in the query
+		count = _count
+	}
+	return []byte(g.String()), count, nil
+}
+
 type DatabaseJSONSerializer struct{}
 
 func (DatabaseJSONSerializer) Serialize(rows pgx.Rows, scalar bool, single bool, info *SchemaInfo) ([]byte, int64, error) {
@@ -839,5 +928,18 @@ func (DatabaseJSONSerializer) Serialize(rows pgx.Rows, scalar bool, single bool,
 	if len(values) == 0 {
 		return []byte("null"), 0, nil
 	}
-	return values[0], 0, nil
+	// with a count, the array follows it
+	var count int64
+	out := values[0]
+	for i, fd := range rows.FieldDescriptions() {
+		if fd.Name == "__count" {
+			count = toInt64(values[i])
+		} else {
+			out = values[i]
+		}
+	}
+	if out == nil {
+		return []byte("null"), count, nil
+	}
+	return out, count, nil
 }
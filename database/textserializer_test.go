@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/jackc/pgx/v5/pgconn"
@@ -172,3 +173,169 @@ func TestSerializeSurfacesDecoderError(t *testing.T) {
 		t.Error("expected Serialize to surface the decoder error, got nil")
 	}
 }
+
+// Multiranges are serialized as arrays of ranges, and domains (over any type)
+// through their base type.
+func TestMultirangeAndDomain(t *testing.T) {
+	const int4range, int4multirange, hoursDomain, hoursArray = 3904, 4451, 900001, 900002
+	int4 := uint32(pgtype.Int4OID)
+	rng := uint32(int4range)
+	info := &SchemaInfo{cachedTypes: map[uint32]Type{
+		int4range:      {Id: int4range, IsRange: true, RangeSubType: &int4},
+		int4multirange: {Id: int4multirange, IsMultirange: true, MultirangeSubType: &rng},
+		hoursDomain:    {Id: hoursDomain, IsDomain: true, DomainBaseType: int4multirange},
+		hoursArray:     {Id: hoursArray, IsArray: true, ArraySubType: hoursDomain},
+	}}
+	bound := func(v int32) []byte {
+		b := binary.BigEndian.AppendUint32(nil, 4)
+		return binary.BigEndian.AppendUint32(b, uint32(v))
+	}
+	// {[1,3),[5,)}
+	r1 := append([]byte{lowerInclusiveMask}, append(bound(1), bound(3)...)...)
+	r2 := append([]byte{lowerInclusiveMask | upperUnboundedMask}, bound(5)...)
+	mr := binary.BigEndian.AppendUint32(nil, 2)
+	mr = append(binary.BigEndian.AppendUint32(mr, uint32(len(r1))), r1...)
+	mr = append(binary.BigEndian.AppendUint32(mr, uint32(len(r2))), r2...)
+	// one-element array of the domain
+	arr := binary.BigEndian.AppendUint32(nil, 1) // dimensions
+	arr = binary.BigEndian.AppendUint32(arr, 0)  // flags
+	arr = binary.BigEndian.AppendUint32(arr, hoursDomain)
+	arr = binary.BigEndian.AppendUint32(arr, 1) // count
+	arr = binary.BigEndian.AppendUint32(arr, 1) // lower bound
+	arr = append(binary.BigEndian.AppendUint32(arr, uint32(len(mr))), mr...)
+
+	cases := []struct {
+		oid      uint32
+		buf      []byte
+		expected string
+	}{
+		{int4multirange, mr, `["[1,3)","[5,)"]`},
+		{hoursDomain, mr, `["[1,3)","[5,)"]`},
+		{hoursArray, arr, `[["[1,3)","[5,)"]]`},
+		{int4range, []byte{emptyMask}, `"empty"`},
+		{int4range, append([]byte{upperInclusiveMask | lowerUnboundedMask}, bound(9)...), `"(,9]"`},
+	}
+	for i, c := range cases {
+		var j JSONSerializer
+		if err := j.appendType(c.buf, c.oid, info); err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if j.String() != c.expected {
+			t.Errorf("%d. expected %s, got %s", i, c.expected, j.String())
+		}
+	}
+}
+
+func TestVectorSerialization(t *testing.T) {
+	const vector, halfvec, sparsevec, notvector = 900010, 900011, 900012, 900013
+	info := &SchemaInfo{cachedTypes: map[uint32]Type{
+		vector:    {Id: vector, Name: "vector", IsBase: true, Extension: "vector"},
+		halfvec:   {Id: halfvec, Name: "halfvec", IsBase: true, Extension: "vector"},
+		sparsevec: {Id: sparsevec, Name: "sparsevec", IsBase: true, Extension: "vector"},
+		notvector: {Id: notvector, Name: "vector", IsDomain: true},
+	}}
+	cases := []struct {
+		oid      uint32
+		buf      string
+		expected string
+	}{
+		{vector, "[1,-0.5,1e-05]", `[1,-0.5,1e-05]`},
+		{halfvec, "[0.25,2]", `[0.25,2]`},
+		// not a JSON array in text format
+		{sparsevec, "{1:1,3:2}/5", `"{1:1,3:2}/5"`},
+		// a type named vector outside pgvector
+		{notvector, "[1,2]", `"[1,2]"`},
+	}
+	for i, c := range cases {
+		var j JSONSerializer
+		if err := j.appendType([]byte(c.buf), c.oid, info); err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if j.String() != c.expected {
+			t.Errorf("%d. expected %s, got %s", i, c.expected, j.String())
+		}
+	}
+}
+
+func TestGeoJSONSerializer(t *testing.T) {
+	feature := []byte(`{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"id":1}}`)
+	count := binary.BigEndian.AppendUint64(nil, 7)
+	cases := []struct {
+		fields   []pgconn.FieldDescription
+		rows     [][][]byte
+		expected string
+		count    int64
+	}{
+		{
+			[]pgconn.FieldDescription{{Name: "__feature", DataTypeOID: pgtype.TextOID}},
+			[][][]byte{{feature}, {feature}},
+			`{"type":"FeatureCollection","features":[` + string(feature) + `,` + string(feature) + `]}`,
+			2,
+		},
+		{
+			// count=exact without data: a single row with the count only
+			[]pgconn.FieldDescription{{Name: "__count", DataTypeOID: pgtype.Int8OID}, {Name: "__feature", DataTypeOID: pgtype.TextOID}},
+			[][][]byte{{count, nil}},
+			`{"type":"FeatureCollection","features":[]}`,
+			7,
+		},
+	}
+	for i, c := range cases {
+		cr := &CustomRows{FieldDescriptions_: c.fields, RawValues_: c.rows, CurrentRow: -1}
+		s := &GeoJSONSerializer{}
+		out, n, err := s.Serialize(cr, false, false, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if string(out) != c.expected || n != c.count {
+			t.Errorf("%d. unexpected %s %d", i, out, n)
+		}
+	}
+}
+
+func TestSerializeDatabaseJSON(t *testing.T) {
+	array := []byte(`[{"id":1},{"id":2}]`)
+	count := binary.BigEndian.AppendUint64(nil, 12)
+	cases := []struct {
+		fields   []pgconn.FieldDescription
+		rows     [][][]byte
+		expected string
+		count    int64
+	}{
+		{
+			[]pgconn.FieldDescription{{Name: "__json", DataTypeOID: pgtype.JSONOID}},
+			[][][]byte{{array}},
+			string(array),
+			0,
+		},
+		{
+			// count=exact with limit: the count comes before the array of the page
+			[]pgconn.FieldDescription{{Name: "__count", DataTypeOID: pgtype.Int8OID}, {Name: "__json", DataTypeOID: pgtype.JSONOID}},
+			[][][]byte{{count, array}},
+			string(array),
+			12,
+		},
+	}
+	for i, c := range cases {
+		cr := &CustomRows{FieldDescriptions_: c.fields, RawValues_: c.rows, CurrentRow: -1}
+		out, n, err := DatabaseJSONSerializer{}.Serialize(cr, false, false, nil)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if string(out) != c.expected || n != c.count {
+			t.Errorf("%d. unexpected %s %d", i, out, n)
+		}
+	}
+}
+
+// Before PostgreSQL 14 the schema queries cannot join pg_range.rngmultitypid
+func TestSchemaQueriesWithoutMultiranges(t *testing.T) {
+	for _, query := range []string{
+		withoutMultiranges.Replace(typesQuery),
+		columnTypesWithoutMultiranges.Replace(columnTypesQuery),
+	} {
+		if strings.Contains(query, "rngmultitypid") || strings.Contains(query, "mr.") {
+			t.Errorf("multiranges still in the query:\n%s", query)
+		}
+	}
+}
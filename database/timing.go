@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ServerTiming collects the durations of the phases of a request,
+// returned in the Server-Timing header when enabled in the configuration
+type ServerTiming struct {
+	phases    []string
+	durations map[string]time.Duration
+}
+
+// serverTimingEnabled checks if the Server-Timing header is enabled in the configuration
+func serverTimingEnabled() bool {
+	return dbe != nil && dbe.config.ServerTimingEnabled
+}
+
+// Add adds a duration to a phase. Phases are reported in the order they are first
+// added and a phase repeated in a request, like a count query, accumulates.
+func (st *ServerTiming) Add(phase string, d time.Duration) {
+	if st.durations == nil {
+		st.durations = map[string]time.Duration{}
+	}
+	if _, ok := st.durations[phase]; !ok {
+		st.phases = append(st.phases, phase)
+	}
+	st.durations[phase] += d
+}
+
+// String formats the phases for the Server-Timing header, with durations in milliseconds
+// (eg. "acquire;dur=0.412, parse;dur=0.021")
+func (st *ServerTiming) String() string {
+	metrics := make([]string, len(st.phases))
+	for i, phase := range st.phases {
+		ms := float64(st.durations[phase].Microseconds()) / 1000
+		metrics[i] = phase + ";dur=" + strconv.FormatFloat(ms, 'f', 3, 64)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// track adds the time elapsed since start to a phase, if the Server-Timing header is enabled
+func (gi *SmoothContext) track(phase string, start time.Time) {
+	if gi.Timing != nil {
+		gi.Timing.Add(phase, time.Since(start))
+	}
+}
+
+// timedRows ends the query phase at the first row: pgx returns from Query before the
+// server has run the statement, so waiting for the first row belongs to the query and
+// the rest to the serialization
+type timedRows struct {
+	pgx.Rows
+	gi      *SmoothContext
+	start   time.Time // start of the current phase
+	started bool      // the first row has been asked
+}
+
+// newTimedRows wraps the rows of a query started at start
+func newTimedRows(gi *SmoothContext, rows pgx.Rows, start time.Time) *timedRows {
+	return &timedRows{Rows: rows, gi: gi, start: start}
+}
+
+func (r *timedRows) Next() bool {
+	next := r.Rows.Next()
+	if !r.started {
+		r.started = true
+		r.gi.track("query", r.start)
+		r.start = time.Now()
+	}
+	return next
+}
+
+// done ends the serialization
+func (r *timedRows) done() {
+	if !r.started {
+		r.started = true
+		r.gi.track("query", r.start)
+		return
+	}
+	r.gi.track("serialize", r.start)
+}
+
+// TrackTiming adds the time elapsed since start to a phase of the request in ctx,
+// for the phases outside of the database package
+func TrackTiming(ctx context.Context, phase string, start time.Time) {
+	if gi := GetSmoothContext(ctx); gi != nil {
+		gi.track(phase, start)
+	}
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	st := &ServerTiming{}
+	st.Add("acquire", 1500*time.Microsecond)
+	st.Add("parse", 20*time.Microsecond)
+	st.Add("query", 3*time.Millisecond)
+	// a second query (eg. the planned count) accumulates
+	st.Add("query", 250*time.Microsecond)
+	want := "acquire;dur=1.500, parse;dur=0.020, query;dur=3.250"
+	if got := st.String(); got != want {
+		t.Errorf("want: %s\n  got:  %s", want, got)
+	}
+
+	gi := &SmoothContext{}
+	gi.track("parse", time.Now())
+	gi.Timing = &ServerTiming{}
+	gi.track("parse", time.Now().Add(-time.Millisecond))
+	if len(gi.Timing.phases) != 1 || gi.Timing.durations["parse"] < time.Millisecond {
+		t.Errorf("unexpected phases %v", gi.Timing.durations)
+	}
+}
+
+func TestTimedRows(t *testing.T) {
+	gi := &SmoothContext{Timing: &ServerTiming{}}
+	rows := newTimedRows(gi, &CustomRows{RawValues_: [][][]byte{{nil}, {nil}}, CurrentRow: -1}, time.Now().Add(-time.Millisecond))
+	for rows.Next() {
+	}
+	rows.done()
+	// the wait for the first row is the query, the rest is the serialization
+	if len(gi.Timing.phases) != 2 || gi.Timing.phases[0] != "query" || gi.Timing.phases[1] != "serialize" ||
+		gi.Timing.durations["query"] < time.Millisecond {
+		t.Errorf("unexpected phases %v %v", gi.Timing.phases, gi.Timing.durations)
+	}
+}
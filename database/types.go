@@ -1,22 +1,30 @@
 package database
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 type Type struct {
-	Id            uint32   `json:"id"`
-	Name          string   `json:"name"`
-	Schema        string   `json:"schema"`
-	IsArray       bool     `json:"isarray"`
-	IsRange       bool     `json:"isrange"`
-	IsComposite   bool     `json:"iscomposite"`
-	IsTable       bool     `json:"istable"`
-	IsEnum        bool     `json:"isenum"`
-	IsDomain      bool     `json:"isdomain"`
-	ArraySubType  uint32   `json:"arraysubtype"`
-	RangeSubType  *uint32  `json:"rangesubtype"`
-	DomainSubType string   `json:"domainsubtype"`
-	SubTypeIds    []uint32 `json:"subtypeids"`
-	SubTypeNames  []string `json:"subtypenames"`
+	Id                uint32   `json:"id"`
+	Name              string   `json:"name"`
+	Schema            string   `json:"schema"`
+	IsArray           bool     `json:"isarray"`
+	IsRange           bool     `json:"isrange"`
+	IsMultirange      bool     `json:"ismultirange"`
+	IsComposite       bool     `json:"iscomposite"`
+	IsTable           bool     `json:"istable"`
+	IsEnum            bool     `json:"isenum"`
+	IsDomain          bool     `json:"isdomain"`
+	IsBase            bool     `json:"isbase"`
+	Extension         string   `json:"extension"` // the extension that created the type, if any
+	ArraySubType      uint32   `json:"arraysubtype"`
+	RangeSubType      *uint32  `json:"rangesubtype"`
+	MultirangeSubType *uint32  `json:"multirangesubtype"` // the range type of a multirange
+	DomainSubType     string   `json:"domainsubtype"`
+	DomainBaseType    uint32   `json:"domainbasetype"`
+	SubTypeIds        []uint32 `json:"subtypeids"`
+	SubTypeNames      []string `json:"subtypenames"`
 }
 
 const typesQuery = `
@@ -25,32 +33,58 @@ const typesQuery = `
 	t.typname name,
 	n.nspname schema,
 	(t.typcategory = 'A') AS isarray,
-	(t.typcategory = 'R') AS isrange,
+	(t.typtype = 'r') AS isrange,
+	(t.typtype = 'm') AS ismultirange,
 	((t.typcategory = 'C' AND COALESCE(c.relkind = 'c', false)) OR 
 	(t.typtype = 'd' AND COALESCE(base_type.typcategory = 'C' AND base_c.relkind = 'c', false))) AS iscomposite,
 	((t.typcategory = 'C' AND COALESCE(c.relkind IN ('r','v','p'), false)) OR
 	(t.typtype = 'd' AND COALESCE(base_type.typcategory = 'C' AND base_c.relkind IN ('r','v','p'), false))) AS istable,
 	(t.typcategory = 'E') AS isenum,
 	(t.typtype = 'd') AS isdomain,
+	(t.typtype = 'b') AS isbase,
+	COALESCE(e.extname, '') extension,
 	t.typelem arraysubtype,
 	r.rngsubtype rangesubtype,
+	mr.rngtypid::int4 multirangesubtype,
 	CASE WHEN t.typtype = 'd' THEN base_type.typname ELSE '' END AS domainsubtype,
+	t.typbasetype::int4 domainbasetype,
 	COALESCE(array_agg(a.atttypid::int4) filter (where a.atttypid is not null), '{}') subtypeids,
 	COALESCE(array_agg(a.attname) filter (where a.attname is not null), '{}') subtypenames
 	FROM pg_type t
 	LEFT JOIN pg_class c ON c.oid = t.typrelid
 	LEFT JOIN pg_attribute a ON a.attrelid = t.typrelid
 	LEFT JOIN pg_range r ON r.rngtypid = t.oid
+	LEFT JOIN pg_range mr ON mr.rngmultitypid = t.oid
 	JOIN pg_namespace n ON n.oid = t.typnamespace
 	LEFT JOIN pg_type base_type ON base_type.oid = t.typbasetype
 	LEFT JOIN pg_class base_c ON base_c.oid = base_type.typrelid
-	GROUP BY t.oid, n.nspname, c.relkind, r.rngsubtype, base_type.typcategory, base_type.typname, base_c.relkind;
+	LEFT JOIN pg_depend d ON d.classid = 'pg_type'::regclass AND d.objid = t.oid AND d.deptype = 'e'
+	LEFT JOIN pg_extension e ON e.oid = d.refobjid
+	GROUP BY t.oid, n.nspname, c.relkind, r.rngsubtype, mr.rngtypid, base_type.typcategory, base_type.typname, base_c.relkind, e.extname;
 `
 
+// multirangeVersion is the first server version with multiranges (pg_range.rngmultitypid)
+const multirangeVersion = 140000
+
+// withoutMultiranges removes the multirange join from typesQuery, for older servers
+var withoutMultiranges = strings.NewReplacer(
+	"mr.rngtypid::int4 multirangesubtype", "NULL::int4 multirangesubtype",
+	"LEFT JOIN pg_range mr ON mr.rngmultitypid = t.oid", "",
+	", mr.rngtypid", "",
+)
+
 func GetTypes(ctx context.Context) ([]Type, error) {
 	conn := GetConn(ctx)
 	types := []Type{}
-	rows, err := conn.Query(ctx, typesQuery)
+	version, err := serverVersionNum(ctx, conn)
+	if err != nil {
+		return types, err
+	}
+	query := typesQuery
+	if version < multirangeVersion {
+		query = withoutMultiranges.Replace(query)
+	}
+	rows, err := conn.Query(ctx, query)
 	if err != nil {
 		return types, err
 	}
@@ -59,8 +93,9 @@ func GetTypes(ctx context.Context) ([]Type, error) {
 	typ := Type{}
 	for rows.Next() {
 		err := rows.Scan(&typ.Id, &typ.Name, &typ.Schema,
-			&typ.IsArray, &typ.IsRange, &typ.IsComposite, &typ.IsTable, &typ.IsEnum, &typ.IsDomain,
-			&typ.ArraySubType, &typ.RangeSubType, &typ.DomainSubType,
+			&typ.IsArray, &typ.IsRange, &typ.IsMultirange, &typ.IsComposite, &typ.IsTable, &typ.IsEnum, &typ.IsDomain,
+			&typ.IsBase, &typ.Extension,
+			&typ.ArraySubType, &typ.RangeSubType, &typ.MultirangeSubType, &typ.DomainSubType, &typ.DomainBaseType,
 			&typ.SubTypeIds, &typ.SubTypeNames)
 		if err != nil {
 			return types, err
@@ -72,3 +107,10 @@ func GetTypes(ctx context.Context) ([]Type, error) {
 	}
 	return types, nil
 }
+
+// IsVector reports whether the type is a pgvector dense vector (vector or halfvec),
+// whose text format ([1,2,3]) is also a JSON array.
+// Other types with the same names, like a domain or a user type, are not vectors.
+func (t *Type) IsVector() bool {
+	return t.IsBase && t.Extension == "vector" && (t.Name == "vector" || t.Name == "halfvec")
+}
@@ -22,7 +22,7 @@ func (s *Server) initCORS() {
 			http.MethodPatch,
 			http.MethodDelete,
 		},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "Prefer", "Accept-Profile", "Content-Profile"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "Prefer", "Accept-Profile", "Content-Profile", "Request-Parser"},
 		AllowCredentials: s.Config.CORSAllowCredentials,
 		MaxAge:           86400,
 	})
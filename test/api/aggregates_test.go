@@ -0,0 +1,163 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestAggregates(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table agg_orders
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/agg_orders",
+		},
+		// drop table agg_customers
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/agg_customers",
+		},
+		// create table agg_customers
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "agg_customers",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "name", "type": "text"}
+				]}`,
+		},
+		// create table agg_orders
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "agg_orders",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "customer_id", "type": "int4", "constraints": ["REFERENCES agg_customers(id)"]},
+					{"name": "status", "type": "text"},
+					{"name": "code", "type": "text"},
+					{"name": "amount", "type": "int4"},
+					{"name": "paid", "type": "bool"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+	reloadDbtest(t)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the customers",
+			Method:      "POST",
+			Query:       "/agg_customers",
+			Body:        `[{"id": 1, "name": "ann"}, {"id": 2, "name": "bob"}]`,
+			Status:      201,
+		},
+		{
+			Description: "insert the orders",
+			Method:      "POST",
+			Query:       "/agg_orders",
+			Body: `[
+				{"id": 1, "customer_id": 1, "status": "paid", "code": "a1", "amount": 100, "paid": true},
+				{"id": 2, "customer_id": 1, "status": "paid", "code": "a2", "amount": 300, "paid": true},
+				{"id": 3, "customer_id": 1, "status": "open", "code": "a3", "amount": 50, "paid": false},
+				{"id": 4, "customer_id": 2, "status": "paid", "code": "b1", "amount": 20, "paid": true},
+				{"id": 5, "customer_id": 2, "status": "open", "code": "b2", "amount": 10, "paid": false}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "array_agg, string_agg and bool_or by customer",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,ids:id.array_agg(),codes:code.string_agg(', '),paid.bool_or()&status=eq.open&order=customer_id",
+			Expected:    `[{"customer_id":1,"ids":[3],"codes":"a3","bool_or":false},{"customer_id":2,"ids":[5],"codes":"b2","bool_or":false}]`,
+			Status:      200,
+		},
+		{
+			Description: "bool_and and sum by customer",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,paid.bool_and(),amount.sum()&status=eq.paid&order=customer_id",
+			Expected:    `[{"customer_id":1,"bool_and":true,"sum":400},{"customer_id":2,"bool_and":true,"sum":20}]`,
+			Status:      200,
+		},
+		{
+			Description: "ordered-set aggregates",
+			Method:      "GET",
+			Query:       "/agg_orders?select=median:amount.percentile_cont(0.5)::int,amount.percentile_disc(0.5)",
+			Expected:    `[{"median":50,"percentile_disc":50}]`,
+			Status:      200,
+		},
+		{
+			Description: "having on a sum",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,amount.sum()&having=amount.sum().gt.100&order=customer_id",
+			Expected:    `[{"customer_id":1,"sum":450}]`,
+			Status:      200,
+		},
+		{
+			Description: "having with or",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,count()&having=or(count().gte.3,amount.max().lt.25)&order=customer_id",
+			Expected:    `[{"customer_id":1,"count":3},{"customer_id":2,"count":2}]`,
+			Status:      200,
+		},
+		{
+			Description: "the count is of the groups kept by having",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,amount.sum()&having=amount.sum().gt.100",
+			Headers:     test.Headers{"Prefer": {"count=exact"}},
+			Expected:    `[{"customer_id":1,"sum":450}]`,
+			ExpectedHeaders: map[string]string{
+				"Content-Range": "0-0/1",
+			},
+			Status: 200,
+		},
+		{
+			Description: "aggregates in an embedded resource",
+			Method:      "GET",
+			Query:       "/agg_customers?select=name,agg_orders(status,amount.sum())&agg_orders.order=status&id=eq.2",
+			Expected:    `[{"name":"bob","agg_orders":[{"status":"open","sum":10},{"status":"paid","sum":20}]}]`,
+			Status:      200,
+		},
+		{
+			Description: "having on a column that is not grouped",
+			Method:      "GET",
+			Query:       "/agg_orders?select=customer_id,amount.sum()&having=status.eq.paid",
+			Status:      400,
+		},
+		{
+			Description: "string_agg needs a separator",
+			Method:      "GET",
+			Query:       "/agg_orders?select=code.string_agg()",
+			Status:      400,
+		},
+		{
+			Description: "percentiles are between 0 and 1",
+			Method:      "GET",
+			Query:       "/agg_orders?select=amount.percentile_cont(2)",
+			Status:      400,
+		},
+		{
+			Description: "unknown aggregate in having",
+			Method:      "GET",
+			Query:       "/agg_orders?select=amount.sum()&having=amount.median().gt.1",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -0,0 +1,98 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestDistinct(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table distinct_orders
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/distinct_orders",
+		},
+		// create table distinct_orders
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "distinct_orders",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "customer_id", "type": "int4"},
+					{"name": "status", "type": "text"},
+					{"name": "created", "type": "int4"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+	reloadDbtest(t)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the orders",
+			Method:      "POST",
+			Query:       "/distinct_orders",
+			Body: `[
+				{"id": 1, "customer_id": 1, "status": "paid", "created": 1},
+				{"id": 2, "customer_id": 1, "status": "open", "created": 2},
+				{"id": 3, "customer_id": 2, "status": "paid", "created": 3},
+				{"id": 4, "customer_id": 2, "status": "paid", "created": 4},
+				{"id": 5, "customer_id": 3, "status": "open", "created": 5}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "distinct rows",
+			Method:      "GET",
+			Query:       "/distinct_orders?select=status&distinct&order=status",
+			Expected:    `[{"status":"open"},{"status":"paid"}]`,
+			Status:      200,
+		},
+		{
+			Description: "the last order of each customer",
+			Method:      "GET",
+			Query:       "/distinct_orders?select=customer_id,id&distinct=customer_id&order=created.desc",
+			Expected:    `[{"customer_id":1,"id":2},{"customer_id":2,"id":4},{"customer_id":3,"id":5}]`,
+			Status:      200,
+		},
+		{
+			Description: "the count is of the distinct rows",
+			Method:      "GET",
+			Query:       "/distinct_orders?select=customer_id&distinct=customer_id",
+			Headers:     test.Headers{"Prefer": {"count=exact"}},
+			Expected:    `[{"customer_id":1},{"customer_id":2},{"customer_id":3}]`,
+			ExpectedHeaders: map[string]string{
+				"Content-Range": "0-2/3",
+			},
+			Status: 200,
+		},
+		{
+			Description: "distinct columns have no direction",
+			Method:      "GET",
+			Query:       "/distinct_orders?distinct=customer_id.desc",
+			Status:      400,
+		},
+		{
+			Description: "distinct and keyset pagination",
+			Method:      "GET",
+			Query:       "/distinct_orders?distinct&after=",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
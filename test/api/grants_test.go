@@ -169,6 +169,29 @@ func TestGrants(t *testing.T) {
 				]`,
 			Status: 200,
 		},
+		{
+			Description:     "allowed methods for user1",
+			Method:          "OPTIONS",
+			Query:           "/table_grants",
+			Headers:         test.Headers{"Authorization": {user1Token}},
+			ExpectedHeaders: map[string]string{"Allow": "OPTIONS, GET, POST"},
+			Status:          200,
+		},
+		{
+			Description:     "allowed methods for user2",
+			Method:          "OPTIONS",
+			Query:           "/table_grants",
+			Headers:         test.Headers{"Authorization": {user2Token}},
+			ExpectedHeaders: map[string]string{"Allow": "OPTIONS, PATCH, DELETE"},
+			Status:          200,
+		},
+		{
+			Description: "allowed methods on a missing table",
+			Method:      "OPTIONS",
+			Query:       "/table_missing",
+			Headers:     test.Headers{"Authorization": {user1Token}},
+			Status:      404,
+		},
 		{
 			Description: "revoke select to user1",
 			Method:      "DELETE",
@@ -0,0 +1,94 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestJsonPathFilters(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table jsonpath_events
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/jsonpath_events",
+		},
+		// create table jsonpath_events
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "jsonpath_events",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "doc", "type": "jsonb"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the events",
+			Method:      "POST",
+			Query:       "/jsonpath_events",
+			Body: `[
+				{"id": 1, "doc": {"items": [{"name": "apple", "qty": 10}], "tags": ["a", "b"]}},
+				{"id": 2, "doc": {"items": [{"name": "Banana", "qty": 2}]}}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "a path with a filter exists",
+			Method:      "GET",
+			Query:       "/jsonpath_events?select=id&doc=jpexists.$.items[*] ? (@.qty > 5)",
+			Expected:    `[{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "a path predicate matches",
+			Method:      "GET",
+			Query:       `/jsonpath_events?select=id&doc=jpmatch.$.items[0].name like_regex "^b" flag "i"`,
+			Expected:    `[{"id":2}]`,
+			Status:      200,
+		},
+		{
+			Description: "a path does not exist",
+			Method:      "GET",
+			Query:       "/jsonpath_events?select=id&doc=not.jpexists.$.tags&order=id",
+			Expected:    `[{"id":2}]`,
+			Status:      200,
+		},
+		{
+			Description: "a path is needed",
+			Method:      "GET",
+			Query:       "/jsonpath_events?doc=jpexists.",
+			Status:      400,
+		},
+		{
+			Description: "a path is needed after the operator",
+			Method:      "GET",
+			Query:       "/jsonpath_events?doc=not.jpexists",
+			Status:      400,
+		},
+		{
+			Description: "unbalanced brackets",
+			Method:      "GET",
+			Query:       "/jsonpath_events?doc=jpexists.$.a[1",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
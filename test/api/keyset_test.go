@@ -0,0 +1,98 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestKeysetPagination(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table table_keyset
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/table_keyset",
+		},
+		// create table table_keyset
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "table_keyset",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "after", "type": "int4"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert records",
+			Method:      "POST",
+			Query:       "/table_keyset",
+			Body:        `[{"id": 1, "after": 10}, {"id": 2, "after": 20}, {"id": 3, "after": 30}, {"id": 4, "after": 40}, {"id": 5, "after": 50}]`,
+			Status:      201,
+		},
+		{
+			Description: "first page",
+			Method:      "GET",
+			Query:       "/table_keyset?select=id&limit=2&after=",
+			Expected:    `[{"id":1},{"id":2}]`,
+			ExpectedHeaders: map[string]string{
+				"Next-Cursor": "WzJd", // [2]
+			},
+			Status: 200,
+		},
+		{
+			Description: "second page, with the count",
+			Method:      "GET",
+			Query:       "/table_keyset?select=id&limit=2&after=WzJd",
+			Headers:     test.Headers{"Prefer": {"count=exact"}},
+			Expected:    `[{"id":3},{"id":4}]`,
+			ExpectedHeaders: map[string]string{
+				"Next-Cursor": "WzRd", // [4]
+			},
+			Status: 200,
+		},
+		{
+			Description: "the last page has no cursor",
+			Method:      "GET",
+			Query:       "/table_keyset?select=id&limit=2&after=WzRd",
+			Expected:    `[{"id":5}]`,
+			ExpectedHeaders: map[string]string{
+				"Next-Cursor": "",
+			},
+			Status: 200,
+		},
+		{
+			Description: "csv pages have no cursor column",
+			Method:      "GET",
+			Query:       "/table_keyset?select=id&limit=1&after=WzRd",
+			Headers:     test.Headers{"Accept": {"text/csv"}},
+			Expected:    "id\n5",
+			Status:      200,
+		},
+		{
+			Description: "a filter on a column named after",
+			Method:      "GET",
+			Query:       "/table_keyset?select=id&after=gt.30",
+			Expected:    `[{"id":4},{"id":5}]`,
+			Status:      200,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
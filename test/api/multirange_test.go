@@ -0,0 +1,73 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestMultirange(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table table_multirange
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/table_multirange",
+		},
+		// create table table_multirange
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "table_multirange",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true},
+					{"name": "hours", "type": "int4multirange", "default": "'{[8,12), [14,18)}'"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert a record with the default multirange",
+			Method:      "POST",
+			Query:       "/table_multirange",
+			Body:        `{"id": 1}`,
+			Status:      201,
+		},
+		{
+			Description: "read the multirange",
+			Method:      "GET",
+			Query:       "/table_multirange",
+			Expected:    `[{"id":1,"hours":["[8,12)","[14,18)"]}]`,
+			Status:      200,
+		},
+		{
+			Description: "overlapping range",
+			Method:      "GET",
+			Query:       "/table_multirange?select=id&hours=ov.[9,10)",
+			Expected:    `[{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "range outside the multirange",
+			Method:      "GET",
+			Query:       "/table_multirange?select=id&hours=ov.[12,14)",
+			Expected:    `[]`,
+			Status:      200,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
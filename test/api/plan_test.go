@@ -0,0 +1,119 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestPlan(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop function plan_insert
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/functions/plan_insert",
+		},
+		// drop table table_plan
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/table_plan",
+		},
+		// create table table_plan
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "table_plan",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true},
+					{"name": "name", "type": "text"}
+				]}`,
+		},
+		// create function plan_insert, a volatile one
+		{
+			Method: "POST",
+			Query:  "/dbtest/functions",
+			Body: `{
+				"name": "plan_insert",
+				"returns": "int4",
+				"definition": "insert into table_plan values (1, 'planned') returning id"
+			}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "json plan",
+			Method:      "GET",
+			Query:       "/table_plan?id=eq.1",
+			Headers:     test.Headers{"Accept": {"application/vnd.pgrst.plan+json"}},
+			ExpectedHeaders: map[string]string{
+				"Content-Type": "application/vnd.pgrst.plan+json; charset=utf-8",
+			},
+			Status: 200,
+		},
+		{
+			Description: "text plan with options",
+			Method:      "GET",
+			Query:       "/table_plan?id=eq.1",
+			Headers:     test.Headers{"Accept": {"application/vnd.pgrst.plan+text; options=analyze|verbose|buffers"}},
+			ExpectedHeaders: map[string]string{
+				"Content-Type": "application/vnd.pgrst.plan+text; charset=utf-8",
+			},
+			Status: 200,
+		},
+		{
+			Description: "analyze a volatile function",
+			Method:      "POST",
+			Query:       "/rpc/plan_insert",
+			Body:        `{}`,
+			Headers:     test.Headers{"Accept": {"application/vnd.pgrst.plan+text; options=analyze"}},
+			Status:      200,
+		},
+		{
+			Description: "the analyzed function is rolled back",
+			Method:      "GET",
+			Query:       "/table_plan",
+			Expected:    `[]`,
+			Status:      200,
+		},
+		{
+			Description: "jq is not applied to plans",
+			Method:      "GET",
+			Query:       "/table_plan?jq=.[0]",
+			Headers:     test.Headers{"Accept": {"application/vnd.pgrst.plan+json"}},
+			Status:      200,
+		},
+		{
+			Description: "unknown plan option",
+			Method:      "GET",
+			Query:       "/table_plan",
+			Headers:     test.Headers{"Accept": {"application/vnd.pgrst.plan+json; options=costs"}},
+			Status:      406,
+		},
+		{
+			Description: "plans are not enabled for the role",
+			Method:      "GET",
+			Query:       "/table_plan",
+			Headers: test.Headers{
+				"Authorization": {user1Token},
+				"Accept":        {"application/vnd.pgrst.plan+json"},
+			},
+			Status: 406,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -0,0 +1,108 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestPreferMaxAffected(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table table_max_affected
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/table_max_affected",
+		},
+		// create table table_max_affected
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "table_max_affected",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true},
+					{"name": "name", "type": "text"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert records",
+			Method:      "POST",
+			Query:       "/table_max_affected",
+			Body:        `[{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}]`,
+			Status:      201,
+		},
+		{
+			Description: "update over max-affected is rolled back",
+			Method:      "PATCH",
+			Query:       "/table_max_affected?id=gt.1",
+			Body:        `{"name": "x"}`,
+			Headers:     test.Headers{"Prefer": {"handling=strict, max-affected=1"}},
+			Status:      400,
+		},
+		{
+			Description: "delete over max-affected is rolled back",
+			Method:      "DELETE",
+			Query:       "/table_max_affected",
+			Headers:     test.Headers{"Prefer": {"handling=strict, max-affected=2"}},
+			ExpectedHeaders: map[string]string{
+				"Preference-Applied": "max-affected=2, handling=strict",
+			},
+			Status: 400,
+		},
+		{
+			Description: "rows are unchanged, a read applies no return preference",
+			Method:      "GET",
+			Query:       "/table_max_affected?order=id",
+			Headers:     test.Headers{"Prefer": {"return=representation, count=exact"}},
+			Expected:    `[{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}]`,
+			ExpectedHeaders: map[string]string{
+				"Preference-Applied": "count=exact",
+			},
+			Status: 200,
+		},
+		{
+			Description: "max-affected is ignored without handling=strict",
+			Method:      "PATCH",
+			Query:       "/table_max_affected?id=eq.3",
+			Body:        `{"name": "z"}`,
+			Headers:     test.Headers{"Prefer": {"max-affected=0"}},
+			Status:      204,
+		},
+		{
+			Description: "update within max-affected",
+			Method:      "PATCH",
+			Query:       "/table_max_affected?id=gt.1",
+			Body:        `{"name": "y"}`,
+			Headers:     test.Headers{"Prefer": {"handling=strict, max-affected=2, return=representation"}},
+			Expected:    `[{"id": 2, "name": "y"}, {"id": 3, "name": "y"}]`,
+			ExpectedHeaders: map[string]string{
+				"Preference-Applied": "return=representation, max-affected=2, handling=strict",
+			},
+			Status: 200,
+		},
+		{
+			Description: "invalid max-affected",
+			Method:      "DELETE",
+			Query:       "/table_max_affected?id=eq.1",
+			Headers:     test.Headers{"Prefer": {"handling=strict, max-affected=many"}},
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -0,0 +1,110 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestSpatial(t *testing.T) {
+
+	requireExtension(t, "postgis")
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table spatial_places
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/spatial_places",
+		},
+		// create table spatial_places, with the point computed from lon and lat
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "spatial_places",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "name", "type": "text"},
+					{"name": "lon", "type": "float8"},
+					{"name": "lat", "type": "float8"},
+					{"name": "geom", "type": "geometry(Point, 4326) GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(lon, lat), 4326)) STORED"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+	reloadDbtest(t)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the places",
+			Method:      "POST",
+			Query:       "/spatial_places",
+			Body: `[
+				{"id": 1, "name": "colosseum", "lon": 12.4922, "lat": 41.8902},
+				{"id": 2, "name": "pantheon", "lon": 12.4768, "lat": 41.8986},
+				{"id": 3, "name": "louvre", "lon": 2.3376, "lat": 48.8606}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "places in a bounding box",
+			Method:      "GET",
+			Query:       "/spatial_places?select=id&geom=bbox.(12.4,41.8,12.6,42.0)&order=id",
+			Expected:    `[{"id":1},{"id":2}]`,
+			Status:      200,
+		},
+		{
+			Description: "places out of a bounding box",
+			Method:      "GET",
+			Query:       "/spatial_places?select=id&geom=not.bbox.(12.4,41.8,12.6,42.0)&order=id",
+			Expected:    `[{"id":3}]`,
+			Status:      200,
+		},
+		{
+			Description: "places intersecting a GeoJSON polygon",
+			Method:      "GET",
+			Query:       `/spatial_places?select=id&geom=intersects.{"type":"Polygon","coordinates":[[[2,48],[3,48],[3,49],[2,49],[2,48]]]}`,
+			Expected:    `[{"id":3}]`,
+			Status:      200,
+		},
+		{
+			Description: "places within a distance",
+			Method:      "GET",
+			Query:       `/spatial_places?select=id&geom=dwithin(0.01)."SRID=4326%3BPOINT(12.49 41.89)"&order=id`,
+			Expected:    `[{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "GeoJSON features",
+			Method:      "GET",
+			Query:       "/spatial_places?select=id,name,geom&id=eq.1",
+			Headers:     test.Headers{"Accept": {"application/geo+json"}},
+			Expected:    `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[12.4922,41.8902]},"properties":{"id":1,"name":"colosseum"}}]}`,
+			Status:      200,
+		},
+		{
+			Description: "dwithin needs a distance",
+			Method:      "GET",
+			Query:       "/spatial_places?geom=dwithin.{}",
+			Status:      400,
+		},
+		{
+			Description: "bbox needs four coordinates",
+			Method:      "GET",
+			Query:       "/spatial_places?geom=bbox.(1,2,3)",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
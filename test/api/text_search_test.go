@@ -0,0 +1,97 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestTextSearch(t *testing.T) {
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table ts_posts
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/ts_posts",
+		},
+		// create table ts_posts
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "ts_posts",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "body", "type": "text"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+	reloadDbtest(t)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the posts",
+			Method:      "POST",
+			Query:       "/ts_posts",
+			Body: `[
+				{"id": 1, "body": "the cat sat on the mat"},
+				{"id": 2, "body": "cat eats cat food with a dog"},
+				{"id": 3, "body": "birds fly"}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "web search",
+			Method:      "GET",
+			Query:       "/ts_posts?select=id&body=wfts(english).cat dog",
+			Expected:    `[{"id":2}]`,
+			Status:      200,
+		},
+		{
+			Description: "order by rank",
+			Method:      "GET",
+			Query:       "/ts_posts?select=id&body=fts(english).cat&order=body.rank(fts(english).cat).desc,id",
+			Expected:    `[{"id":2},{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "select the rank",
+			Method:      "GET",
+			Query:       "/ts_posts?select=id,score:body.rank_cd(fts(english).bird)&id=eq.1",
+			Expected:    `[{"id":1,"score":0}]`,
+			Status:      200,
+		},
+		{
+			Description: "select a headline",
+			Method:      "GET",
+			Query:       "/ts_posts?select=id,body.headline(fts(english).dog)&id=eq.2",
+			Expected:    `[{"id":2,"headline":"cat eats cat food with a <b>dog</b>"}]`,
+			Status:      200,
+		},
+		{
+			Description: "headline cannot be used in order",
+			Method:      "GET",
+			Query:       "/ts_posts?order=body.headline(wfts.cat)",
+			Status:      400,
+		},
+		{
+			Description: "rank needs a text search operator",
+			Method:      "GET",
+			Query:       "/ts_posts?order=body.rank(like.cat)",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -0,0 +1,100 @@
+package test_api
+
+import (
+	"testing"
+
+	"github.com/sted/smoothdb/test"
+)
+
+func TestTrigramSimilarity(t *testing.T) {
+
+	requireExtension(t, "pg_trgm")
+
+	cmdConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/admin/databases",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	commands := []test.Command{
+		// drop table trgm_people
+		{
+			Method: "DELETE",
+			Query:  "/dbtest/tables/trgm_people",
+		},
+		// create table trgm_people
+		{
+			Method: "POST",
+			Query:  "/dbtest/tables",
+			Body: `{
+				"name": "trgm_people",
+				"columns": [
+					{"name": "id", "type": "int4", "notnull": true, "constraints": ["PRIMARY KEY"]},
+					{"name": "name", "type": "text"}
+				]}`,
+		},
+	}
+	test.Prepare(cmdConfig, commands)
+
+	testConfig := test.Config{
+		BaseUrl:       "http://localhost:8082/api/dbtest",
+		CommonHeaders: test.Headers{"Authorization": {adminToken}},
+	}
+
+	tests := []test.Test{
+		{
+			Description: "insert the people",
+			Method:      "POST",
+			Query:       "/trgm_people",
+			Body: `[
+				{"id": 1, "name": "bob smith"},
+				{"id": 2, "name": "rob smyth"},
+				{"id": 3, "name": "alice jones"},
+				{"id": 4, "name": "o'brien, j"}
+			]`,
+			Status: 201,
+		},
+		{
+			Description: "similar names",
+			Method:      "GET",
+			Query:       "/trgm_people?select=id&name=sim.bob smith&order=id",
+			Expected:    `[{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "names with a similar word",
+			Method:      "GET",
+			Query:       "/trgm_people?select=id&name=wsim.smith&order=id",
+			Expected:    `[{"id":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "the most similar first",
+			Method:      "GET",
+			Query:       "/trgm_people?select=id&order=name.similar(bob smith),id&id=lt.4",
+			Expected:    `[{"id":1},{"id":2},{"id":3}]`,
+			Status:      200,
+		},
+		{
+			Description: "select the similarity",
+			Method:      "GET",
+			Query:       "/trgm_people?select=id,name.similar(bob smith)&id=eq.1",
+			Expected:    `[{"id":1,"name_similarity":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "the text is taken as it is",
+			Method:      "GET",
+			Query:       "/trgm_people?select=score:name.similar(o'brien, j)&id=eq.4",
+			Expected:    `[{"score":1}]`,
+			Status:      200,
+		},
+		{
+			Description: "similar needs a text",
+			Method:      "GET",
+			Query:       "/trgm_people?order=name.similar()",
+			Status:      400,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -206,6 +206,16 @@ func TestPostgREST_Insert(t *testing.T) {
 		// 							 , "Location" <:> "/projects?id=eq.11"
 		// 							 , "Content-Range" <:> "*/*" ]
 		// 			}
+		{
+			Description:     "requesting headers only representation returns the location header when selecting without PK",
+			Method:          "POST",
+			Query:           "/projects?select=name,client_id",
+			Body:            `{"id":11,"name":"New Project","client_id":2}`,
+			Headers:         test.Headers{"Prefer": {"return=headers-only"}},
+			ExpectedEmpty:   true,
+			ExpectedHeaders: map[string]string{"Location": "/api/pgrest/projects?id=eq.11", "Content-Range": "*/1"},
+			Status:          201,
+		},
 
 		// 	  when (actualPgVersion >= pgVersion110) $
 		// 		it "should not throw and return location header for partitioned tables when selecting without PK" $
@@ -219,6 +229,16 @@ func TestPostgREST_Insert(t *testing.T) {
 		// 							   , "Location" <:> "/car_models?name=eq.Enzo&year=eq.2021"
 		// 							   , "Content-Range" <:> "*/*" ]
 		// 			  }
+		{
+			Description:     "returns the location header for partitioned tables",
+			Method:          "POST",
+			Query:           "/car_models",
+			Body:            `{"name":"Enzo","year":2021}`,
+			Headers:         test.Headers{"Prefer": {"return=headers-only"}},
+			ExpectedEmpty:   true,
+			ExpectedHeaders: map[string]string{"Location": "/api/pgrest/car_models?name=eq.Enzo&year=eq.2021"},
+			Status:          201,
+		},
 
 		// 	context "requesting no representation" $
 		// 	  it "should not throw and return no location header when selecting without PK" $
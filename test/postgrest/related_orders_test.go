@@ -147,110 +147,118 @@ func TestPostgREST_RelatedOrders(t *testing.T) {
 
 // context "related conditions through null operator on embed"
 
-// it "works on a many-to-one relationship"
-// {
-// 	Description: "null operator on embed works on many-to-one not null",
-// 	Query:       "/projects?select=name,clients()&clients=not.is.null",
-// 	Expected: `[
-// 		{"name":"Windows 7"},
-// 		{"name":"Windows 10"},
-// 		{"name":"IOS"},
-// 		{"name":"OSX"}
-// 	]`,
-// 	Status: 200,
-// },
-// {
-// 	Description: "null operator on embed works on many-to-one is null",
-// 	Query:       "/projects?select=name,clients()&clients=is.null",
-// 	Expected:    `[{"name":"Orphan"}]`,
-// 	Status:      200,
-// },
-// {
-// 	Description: "null operator on embed works on computed many-to-one is null",
-// 	Query:       "/projects?select=name,computed_clients()&computed_clients=is.null",
-// 	Expected:    `[{"name":"Orphan"}]`,
-// 	Status:      200,
-// },
+func TestPostgREST_NullEmbedFilters(t *testing.T) {
 
-// it "works on a one-to-many relationship"
-// {
-// 	Description: "null operator on embed works on one-to-many not null",
-// 	Query:       "/entities?select=name,child_entities()&child_entities=not.is.null",
-// 	Expected: `[
-// 		{"name":"entity 1"},
-// 		{"name":"entity 2"}
-// 	]`,
-// 	Status: 200,
-// },
-// {
-// 	Description: "null operator on embed works on one-to-many is null",
-// 	Query:       "/entities?select=name,child_entities()&child_entities=is.null",
-// 	Expected: `[
-// 		{"name":"entity 3"},
-// 		{"name":null}
-// 	]`,
-// 	Status: 200,
-// },
-// {
-// 	Description: "null operator on embed works on one-to-many is null with alias",
-// 	Query:       "/entities?select=name,childs:child_entities()&childs=is.null",
-// 	Expected: `[
-// 		{"name":"entity 3"},
-// 		{"name":null}
-// 	]`,
-// 	Status: 200,
-// },
+	tests := []test.Test{
 
-// it "works on a many-to-many relationship"
-// {
-// 	Description: "null operator on embed works on many-to-many not null",
-// 	Query:       "/users?select=name,tasks()&tasks.id=eq.1&tasks=not.is.null",
-// 	Expected: `[
-// 		{"name":"Angela Martin"},
-// 		{"name":"Dwight Schrute"}
-// 	]`,
-// 	Status: 200,
-// },
-// {
-// 	Description: "null operator on embed works on many-to-many is null",
-// 	Query:       "/users?select=name,tasks()&tasks.id=eq.1&tasks=is.null",
-// 	Expected:    `[{"name":"Michael Scott"}]`,
-// 	Status:      200,
-// },
+		// it "works on a many-to-one relationship"
+		{
+			Description: "null operator on embed works on many-to-one not null",
+			Query:       "/projects?select=name,clients()&clients=not.is.null",
+			Expected: `[
+			{"name":"Windows 7"},
+			{"name":"Windows 10"},
+			{"name":"IOS"},
+			{"name":"OSX"}
+		]`,
+			Status: 200,
+		},
+		{
+			Description: "null operator on embed works on many-to-one is null",
+			Query:       "/projects?select=name,clients()&clients=is.null",
+			Expected:    `[{"name":"Orphan"}]`,
+			Status:      200,
+		},
+		{
+			Description: "null operator on embed works on computed many-to-one is null",
+			Query:       "/projects?select=name,computed_clients()&computed_clients=is.null",
+			Expected:    `[{"name":"Orphan"}]`,
+			Status:      200,
+		},
 
-// it "works on nested embeds"
-// {
-// 	Description: "null operator on embed works on nested embeds",
-// 	Query:       "/entities?select=name,child_entities(name,grandchild_entities())&child_entities.grandchild_entities=not.is.null&child_entities=not.is.null",
-// 	Expected:    `[{"name":"entity 1","child_entities":[{"name":"child entity 1"}, {"name":"child entity 2"}]}]`,
-// 	Status:      200,
-// },
+		// it "works on a one-to-many relationship"
+		{
+			Description: "null operator on embed works on one-to-many not null",
+			Query:       "/entities?select=name,child_entities()&child_entities=not.is.null",
+			Expected: `[
+			{"name":"entity 1"},
+			{"name":"entity 2"}
+		]`,
+			Status: 200,
+		},
+		{
+			Description: "null operator on embed works on one-to-many is null",
+			Query:       "/entities?select=name,child_entities()&child_entities=is.null",
+			Expected: `[
+			{"name":"entity 3"},
+			{"name":null}
+		]`,
+			Status: 200,
+		},
+		{
+			Description: "null operator on embed works on one-to-many is null with alias",
+			Query:       "/entities?select=name,childs:child_entities()&childs=is.null",
+			Expected: `[
+			{"name":"entity 3"},
+			{"name":null}
+		]`,
+			Status: 200,
+		},
 
-// it "can do an or across embeds"
-// {
-// 	Description: "null operator on embed can do an or across embeds",
-// 	Query:       "/client?select=*,clientinfo(),contact()&clientinfo.other=ilike.*main*&contact.name=ilike.*tabby*&or=(clientinfo.not.is.null,contact.not.is.null)",
-// 	Expected: `[
-// 		{"id":1,"name":"Walmart"},
-// 		{"id":2,"name":"Target"}
-// 	]`,
-// 	Status: 200,
-// },
+		// it "works on a many-to-many relationship"
+		{
+			Description: "null operator on embed works on many-to-many not null",
+			Query:       "/users?select=name,tasks()&tasks.id=eq.1&tasks=not.is.null",
+			Expected: `[
+			{"name":"Angela Martin"},
+			{"name":"Dwight Schrute"}
+		]`,
+			Status: 200,
+		},
+		{
+			Description: "null operator on embed works on many-to-many is null",
+			Query:       "/users?select=name,tasks()&tasks.id=eq.1&tasks=is.null",
+			Expected:    `[{"name":"Michael Scott"}]`,
+			Status:      200,
+		},
 
-// it "only works with is null or is not null operators"
-// {
-// 	Description: "null operator on embed only works with is null or not is null",
-// 	Query:       "/projects?select=name,clients(*)&clients=eq.3",
-// 	Status:      400,
-// },
+		// it "works on nested embeds"
+		{
+			Description: "null operator on embed works on nested embeds",
+			Query:       "/entities?select=name,child_entities(name,grandchild_entities())&child_entities.grandchild_entities=not.is.null&child_entities=not.is.null",
+			Expected:    `[{"name":"entity 1","child_entities":[{"name":"child entity 1"}, {"name":"child entity 2"}]}]`,
+			Status:      200,
+		},
 
-// it "doesn't interfere filtering when embedding using the column name"
-// {
-// 	Description: "null operator on embed doesn't interfere with column name filtering",
-// 	Query:       "/projects?select=name,client_id,client:client_id(name)&client_id=eq.2",
-// 	Expected: `[
-// 		{"name":"IOS","client_id":2,"client":{"name":"Apple"}},
-// 		{"name":"OSX","client_id":2,"client":{"name":"Apple"}}
-// 	]`,
-// 	Status: 200,
-// },
+		// it "can do an or across embeds"
+		{
+			Description: "null operator on embed can do an or across embeds",
+			Query:       "/client?select=*,clientinfo(),contact()&clientinfo.other=ilike.*main*&contact.name=ilike.*tabby*&or=(clientinfo.not.is.null,contact.not.is.null)",
+			Expected: `[
+			{"id":1,"name":"Walmart"},
+			{"id":2,"name":"Target"}
+		]`,
+			Status: 200,
+		},
+
+		// it "only works with is null or is not null operators"
+		{
+			Description: "null operator on embed only works with is null or not is null",
+			Query:       "/projects?select=name,clients(*)&clients=eq.3",
+			Status:      400,
+		},
+
+		// it "doesn't interfere filtering when embedding using the column name"
+		{
+			Description: "null operator on embed doesn't interfere with column name filtering",
+			Query:       "/projects?select=name,client_id,client:client_id(name)&client_id=eq.2",
+			Expected: `[
+			{"name":"IOS","client_id":2,"client":{"name":"Apple"}},
+			{"name":"OSX","client_id":2,"client":{"name":"Apple"}}
+		]`,
+			Status: 200,
+		},
+	}
+
+	test.Execute(t, testConfig, tests)
+}
@@ -1389,6 +1389,14 @@ func TestPostgREST_RPC(t *testing.T) {
 		//           [json| { "v": ["hi", "hello", "there"] } |]
 		//         `shouldRespondWith`
 		//           [json|["hi", "hello", "there"]|]
+		{
+			Description: "works with POST",
+			Method:      "POST",
+			Query:       "/rpc/variadic_param",
+			Body:        `{ "v": ["hi", "hello", "there"] }`,
+			Expected:    `["hi", "hello", "there"]`,
+			Status:      200,
+		},
 
 		//     context "works with GET and repeated params" $ do
 		//       it "n=0 (through DEFAULT)" $
@@ -1406,18 +1414,24 @@ func TestPostgREST_RPC(t *testing.T) {
 		//         get "/rpc/variadic_param?v=hi"
 		//           `shouldRespondWith`
 		//             [json|["hi"]|]
-		// @@ support for variadic is minimal (should not use the sintax "v := ...")
-		// {
-		// 	Description: "works with GET and repeated params",
-		// 	Method:      "GET",
-		// 	Query:       "/rpc/variadic_param?v=hi",
-		// 	Expected:    `["hi"]`,
-		// 	Status:      200,
-		// },
+		{
+			Description: "works with GET and repeated params, n=1",
+			Method:      "GET",
+			Query:       "/rpc/variadic_param?v=hi",
+			Expected:    `["hi"]`,
+			Status:      200,
+		},
 		//       it "n>1" $
 		//         get "/rpc/variadic_param?v=hi&v=there"
 		//           `shouldRespondWith`
 		//             [json|["hi", "there"]|]
+		{
+			Description: "works with GET and repeated params, n>1",
+			Method:      "GET",
+			Query:       "/rpc/variadic_param?v=hi&v=there",
+			Expected:    `["hi", "there"]`,
+			Status:      200,
+		},
 
 		//     context "works with POST and repeated params from html form" $ do
 		//       it "n=0 (through DEFAULT)" $
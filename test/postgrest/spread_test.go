@@ -119,7 +119,7 @@ func TestPostgREST_Spread(t *testing.T) {
 		//     , matchHeaders = [matchContentTypeJson]
 		//     }
 		{
-			Description: "fails when is not a to-one relationship",
+			Description: "fails when spreading all the columns of a to-many relationship",
 			Method:      "GET",
 			Query:       "/clients?select=*,...projects(*)",
 			Expected:    ``,
@@ -128,7 +128,7 @@ func TestPostgREST_Spread(t *testing.T) {
 
 		// @@ computed table
 		{
-			Description: "fails when is not a to-one computed relationship",
+			Description: "fails when spreading all the columns of a to-many computed relationship",
 			Method:      "GET",
 			Query:       "/designers?select=*,...computed_videogames(*)",
 			Expected:    ``,
@@ -191,6 +191,34 @@ func TestPostgREST_Spread(t *testing.T) {
 				    }]`,
 			Status: 200,
 		},
+		{
+			Description: "works on a one-to-many relationship, with a column array",
+			Method:      "GET",
+			Query:       "/clients?select=name,...projects(projects:name)&projects.order=id",
+			Expected: `[
+				{"name":"Microsoft","projects":["Windows 7","Windows 10"]},
+				{"name":"Apple","projects":["IOS","OSX"]}
+			]`,
+			Status: 200,
+		},
+		{
+			Description: "works on a many-to-many relationship, with the junction columns",
+			Method:      "GET",
+			Query:       "/users?select=name,...tasks(task:name,...users_tasks!junction(task_id))&tasks.order=id&limit=1",
+			Expected: `[{
+				"name":"Angela Martin",
+				"task":["Design w7","Code w7","Design w10","Code w10"],
+				"task_id":[1,2,3,4]
+			}]`,
+			Status: 200,
+		},
+		{
+			Description: "returns empty arrays when there are no related rows",
+			Method:      "GET",
+			Query:       "/projects?select=name,...tasks(task:name)&id=eq.5",
+			Expected:    `[{"name":"Orphan","task":[]}]`,
+			Status:      200,
+		},
 	}
 
 	test.Execute(t, testConfig, tests)
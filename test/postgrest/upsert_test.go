@@ -576,6 +576,162 @@ func TestPostgREST_Upsert(t *testing.T) {
 		// 		[json| [ { "name": "Ruby", "rank": 11 } ]|]
 		// 		`shouldRespondWith` [json|{ "name": "Ruby", "rank": 11 }|] { matchHeaders = [matchContentTypeSingular] }
 
+		{
+			Description: "with PUT fails if Range is specified",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Javascript",
+			Body:        `[ { "name": "Javascript", "rank": 1 } ]`,
+			Headers:     test.Headers{"Range": {"0-5"}},
+			Status:      400,
+		},
+		{
+			Description: "with PUT fails if limit is specified",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Javascript&limit=1",
+			Body:        `[ { "name": "Javascript", "rank": 1 } ]`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT fails if offset is specified",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Javascript&offset=1",
+			Body:        `[ { "name": "Javascript", "rank": 1 } ]`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT rejects every other filter than pk cols eq's",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?rank=eq.19",
+			Body:        `[ { "name": "Go", "rank": 19 } ]`,
+			Expected:    `{"subsystem":"network","message":"Filters must include all and only primary key columns with 'eq' operators","code":"","hint":"","details":null,"position":0}`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT rejects a negated filter",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=not.eq.Java",
+			Body:        `[ { "name": "Go", "rank": 19 } ]`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT rejects an in filter",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=in.(Go)",
+			Body:        `[ { "name": "Go", "rank": 19 } ]`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT rejects a logic tree",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?and=(name.eq.Go)",
+			Body:        `[ { "name": "Go", "rank": 19 } ]`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT fails if not all composite key cols are specified as eq filters",
+			Method:      "PUT",
+			Query:       "/employees?first_name=eq.Susan",
+			Body:        `[ { "first_name": "Susan", "last_name": "Heidt", "salary": "48000", "company": "GEX", "occupation": "Railroad engineer" } ]`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT fails if the uri primary key doesn't match the payload primary key",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.MATLAB",
+			Body:        `[ { "name": "Perl", "rank": 17 } ]`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT fails if the uri composite primary key doesn't match the payload",
+			Method:      "PUT",
+			Query:       "/employees?first_name=eq.Wendy&last_name=eq.Anderson",
+			Body:        `[ { "first_name": "Susan", "last_name": "Heidt", "salary": "48000", "company": "GEX", "occupation": "Railroad engineer" } ]`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT fails if the table has no PK",
+			Method:      "PUT",
+			Query:       "/no_pk?a=eq.one&b=eq.two",
+			Body:        `[ { "a": "one", "b": "two" } ]`,
+			Status:      405,
+		},
+		{
+			Description: "with PUT fails if the payload is partial",
+			Method:      "PUT",
+			Query:       "/employees?first_name=eq.Susan&last_name=eq.Heidt",
+			Body:        `{ "first_name": "Susan", "last_name": "Heidt" }`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT fails if the payload has more than one row",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Java",
+			Body:        `[ { "name": "Java", "rank": 19 }, { "name": "Swift", "rank": 12 } ]`,
+			Status:      400,
+		},
+		{
+			Description: "with PUT inserting row succeeds on table with single pk col",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Go",
+			Body:        `[ { "name": "Go", "rank": 19 } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "name": "Go", "rank": 19 } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT inserting row succeeds on table with composite pk",
+			Method:      "PUT",
+			Query:       "/employees?first_name=eq.Susan&last_name=eq.Heidt",
+			Body:        `[ { "first_name": "Susan", "last_name": "Heidt", "salary": "48000", "company": "GEX", "occupation": "Railroad engineer" } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "first_name": "Susan", "last_name": "Heidt", "salary": 48000, "company": "GEX", "occupation": "Railroad engineer" } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT inserting row succeeds on a partitioned table with composite pk",
+			Method:      "PUT",
+			Query:       "/car_models?name=eq.Supra&year=eq.2021",
+			Body:        `[ { "name": "Supra", "year": 2021, "car_brand_name": null } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "name": "Supra", "year": 2021, "car_brand_name": null } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT inserting row succeeds if the table has only PK cols and no other cols",
+			Method:      "PUT",
+			Query:       "/only_pk?id=eq.10",
+			Body:        `[ { "id": 10 } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "id": 10 } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT updating row succeeds on table with single pk col",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Java",
+			Body:        `[ { "name": "Java", "rank": 13 } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "name": "Java", "rank": 13 } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT updating row succeeds on a partitioned table with composite pk",
+			Method:      "PUT",
+			Query:       "/car_models?name=eq.DeLorean&year=eq.1981",
+			Body:        `[ { "name": "DeLorean", "year": 1981, "car_brand_name": null } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}},
+			Expected:    `[ { "name": "DeLorean", "year": 1981, "car_brand_name": null } ]`,
+			Status:      200,
+		},
+		{
+			Description: "with PUT works with return=representation and vnd.pgrst.object+json",
+			Method:      "PUT",
+			Query:       "/tiobe_pls?name=eq.Ruby",
+			Body:        `[ { "name": "Ruby", "rank": 11 } ]`,
+			Headers:     test.Headers{"Prefer": {"return=representation"}, "Accept": {"application/vnd.pgrst.object+json"}},
+			Expected:    `{ "name": "Ruby", "rank": 11 }`,
+			Status:      200,
+		},
 		//   context "with a camel case pk column" $ do
 		// 	it "works with POST and merge-duplicates" $ do
 		// 	  request methodPost "/UnitTest"
@@ -645,6 +801,21 @@ func TestPostgREST_Upsert(t *testing.T) {
 		// 		  }
 		// 	  get "/UnitTest?idUnitTest=eq.1" `shouldRespondWith`
 		// 		[json| [ { "idUnitTest": 1, "nameUnitTest": "unit test 1" } ]|]
+		{
+			Description:   "with a camel case pk column works with PUT",
+			Method:        "PUT",
+			Query:         "/UnitTest?idUnitTest=eq.1",
+			Body:          `[ { "idUnitTest": 1, "nameUnitTest": "unit test 1" } ]`,
+			ExpectedEmpty: true,
+			Status:        204,
+		},
+		{
+			Description: "with a camel case pk column gets the row put",
+			Method:      "GET",
+			Query:       "/UnitTest?idUnitTest=eq.1",
+			Expected:    `[ { "idUnitTest": 1, "nameUnitTest": "unit test 1" } ]`,
+			Status:      200,
+		},
 	}
 
 	test.Execute(t, testConfig, tests)